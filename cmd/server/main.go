@@ -8,17 +8,27 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"go_oauth2_server/internal/config"
 	"go_oauth2_server/internal/handlers"
+	"go_oauth2_server/internal/hasher"
+	"go_oauth2_server/internal/jwt"
+	"go_oauth2_server/internal/mailer"
+	"go_oauth2_server/internal/mfa"
+	"go_oauth2_server/internal/ratelimit"
 	"go_oauth2_server/internal/storage"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -71,9 +81,17 @@ func init() {
 	prometheus.MustRegister(oauth2TokensValidated)
 }
 
+// keyReloadInterval период перечитывания ключей подписи из БД
+const keyReloadInterval = time.Minute
+
 func main() {
-	err := run()
-	if err != nil {
+	command := run
+	// rotate-keys — ручная ротация ключа подписи в БД
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		command = rotateKeys
+	}
+
+	if err := command(); err != nil {
 		os.Exit(1)
 	}
 }
@@ -89,52 +107,149 @@ func run() error {
 	}
 
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		return err
+	}
 
-	// Ожидание готовности БД до открытия соединения ()
-	if err := waitForDB(cfg.DatabaseURL); err != nil {
-		logger.Error("Database not ready", "error", err)
+	db, err := openDatabase(cfg, logger)
+	if err != nil {
 		return err
 	}
+	defer db.Close()
 
-	// Подключение к базе данных с таймаутом
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	store, err := newStore(db, cfg)
+	if err != nil {
+		logger.Error("Invalid hash configuration", "error", err)
+		return err
+	}
 
-	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err := hashStoredTokens(store, logger); err != nil {
+		return err
+	}
+
+	runCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	keys, keyManager, err := loadSigningKeys(runCtx, cfg, store, logger)
 	if err != nil {
-		logger.Error("Failed to connect to database", "error", err)
+		logger.Error("Failed to load JWT signing keys", "error", err)
 		return err
 	}
-	defer db.Close()
+	logger.Info("JWT signing key loaded", "alg", keys.SigningKey().Method.Alg(), "kid", keys.SigningKey().KID)
 
-	if err := db.PingContext(ctx); err != nil {
-		logger.Error("Failed to ping database", "error", err)
+	if keyManager != nil {
+		go keyManager.Run(runCtx, cfg.JWTKeyRotationInterval, keyReloadInterval)
+	}
+
+	if cfg.CleanupInterval > 0 {
+		go cleanExpired(runCtx, store, cfg.CleanupInterval, logger)
+	}
+
+	limiter, err := newRateLimiter(cfg, store)
+	if err != nil {
+		logger.Error("Invalid rate limit configuration", "error", err)
 		return err
 	}
 
-	if err := runMigrations(cfg.DatabaseURL); err != nil {
-		logger.Error("Failed to run migrations", "error", err)
+	passkeys, err := newWebAuthn(cfg)
+	if err != nil {
+		logger.Error("Invalid WebAuthn configuration", "error", err)
 		return err
 	}
 
-	store := storage.NewPostgresStore(db)
-	h := handlers.New(store, logger, cfg)
+	mail, err := newMailer(cfg, logger)
+	if err != nil {
+		logger.Error("Invalid mail configuration", "error", err)
+		return err
+	}
+
+	h := handlers.New(store, keys, logger, cfg, limiter, passkeys, mail)
+
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Error("Invalid TRUSTED_PROXIES", "error", err)
+		return err
+	}
 
 	router := chi.NewRouter()
 
 	// Middleware
+	router.Use(realIPMiddleware(trustedProxies))
 	router.Use(loggingMiddleware(logger))
 	router.Use(corsMiddleware)
 	router.Use(metricsMiddleware)
 
 	// Routes
 	router.Route("/", func(r chi.Router) {
-		r.HandleFunc("/authorize", h.Authorize)
-		r.HandleFunc("/token", h.Token)
-		r.HandleFunc("/introspect", h.Introspect)
-		r.HandleFunc("/clients", h.RegisterClient)
+		r.Group(func(r chi.Router) {
+			r.Use(h.RateLimit(handlers.RateLimitAuthorize))
+			r.HandleFunc(handlers.PathAuthorize, h.Authorize)
+			r.HandleFunc(handlers.PathLogin, h.Login)
+			r.Post(handlers.PathLoginMFA, h.LoginMFA)
+			r.Post(handlers.PathLoginPasskey+"/begin", h.BeginPasskeyLogin)
+			r.Post(handlers.PathLoginPasskey+"/finish", h.FinishPasskeyLogin)
+			r.Post(handlers.PathLoginMFAPasskey+"/begin", h.BeginPasskeySecondFactor)
+			r.Post(handlers.PathLoginMFAPasskey+"/finish", h.FinishPasskeySecondFactor)
+			r.Post(handlers.PathEmailVerification, h.ResendEmailVerification)
+			r.Get(handlers.PathEmailVerify, h.VerifyEmail)
+			r.Post(handlers.PathPasswordForgot, h.ForgotPassword)
+			r.Get(handlers.PathPasswordReset, h.PasswordResetForm)
+			r.Post(handlers.PathPasswordReset, h.ResetPassword)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(h.RateLimit(handlers.RateLimitToken))
+			r.HandleFunc(handlers.PathToken, h.Token)
+			r.HandleFunc(handlers.PathRevoke, h.Revoke)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(h.RateLimit(handlers.RateLimitIntrospect))
+			r.HandleFunc(handlers.PathIntrospect, h.Introspect)
+			r.HandleFunc(handlers.PathUserInfo, h.UserInfo)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(h.RateLimit(handlers.RateLimitManagement))
+			r.HandleFunc(handlers.PathClients, h.RegisterClient)
+			r.Get(handlers.PathConsents, h.ListConsents)
+			r.Delete(handlers.PathConsents+"/{client_id}", h.RevokeConsent)
+			r.HandleFunc("/users", h.RegisterUser)
+			r.Get(handlers.PathMFATOTP, h.TOTPStatus)
+			r.Post(handlers.PathMFATOTP, h.EnrollTOTP)
+			r.Delete(handlers.PathMFATOTP, h.DisableTOTP)
+			r.Post(handlers.PathMFATOTP+"/confirm", h.ConfirmTOTP)
+			r.Post(handlers.PathMFARecoveryCodes, h.RegenerateRecoveryCodes)
+			r.Get(handlers.PathPasskeys, h.ListPasskeys)
+			r.Post(handlers.PathPasskeys+"/register/begin", h.BeginPasskeyRegistration)
+			r.Post(handlers.PathPasskeys+"/register/finish", h.FinishPasskeyRegistration)
+			r.Delete(handlers.PathPasskeys+"/{id}", h.DeletePasskey)
+			r.Route(handlers.PathMe, func(r chi.Router) {
+				r.Use(h.RequireAccount)
+				r.Get("/", h.GetProfile)
+				r.Patch("/", h.UpdateProfile)
+				r.Delete("/", h.DeleteAccount)
+				r.Post("/password", h.ChangePassword)
+				r.Get("/tokens", h.ListAccountTokens)
+				r.Delete("/tokens/{id}", h.RevokeAccountToken)
+				r.Get("/sessions", h.ListAccountSessions)
+				r.Delete("/sessions/{id}", h.RevokeAccountSession)
+			})
+			r.Route(handlers.PathAdmin, func(r chi.Router) {
+				r.Use(h.RequireAdmin)
+				r.Delete("/lockouts/{subject_type}/{subject}", h.UnlockLogin)
+				r.Route("/clients", func(r chi.Router) {
+					r.Get("/", h.ListClients)
+					r.Get("/{id}", h.GetClient)
+					r.Patch("/{id}", h.UpdateClient)
+					r.Delete("/{id}", h.DeleteClient)
+					r.Post("/{id}/secret", h.RotateClientSecret)
+				})
+			})
+		})
 		r.HandleFunc("/health", h.Health)
-		r.HandleFunc("/users", h.RegisterUser)
+		r.Get(handlers.PathJWKS, h.JWKS)
+		r.Get(handlers.PathOpenIDConfig, h.OpenIDConfiguration)
+		r.Get(handlers.PathAuthorizationServer, h.AuthorizationServerMetadata)
+		r.Get(handlers.PathWebAuthnScript, h.WebAuthnScript)
 		// Prometheus метрики
 		r.Handle("/metrics", promhttp.Handler())
 	})
@@ -159,6 +274,7 @@ func run() error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logger.Info("Shutting down server...")
+	stopBackground()
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
@@ -172,6 +288,229 @@ func run() error {
 	return nil
 }
 
+// rotateKeys выполняет ротацию ключа подписи в БД. Работающие реплики подхватят
+// новый ключ при очередном перечитывании или при встрече незнакомого kid.
+func rotateKeys() error {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+
+	if err := godotenv.Load(); err != nil {
+		logger.Info("No .env file found, using environment variables")
+	}
+
+	cfg := config.Load()
+	if !cfg.StoredSigningKeys() {
+		err := fmt.Errorf("key rotation requires JWT_SIGNING_ALG RS256, ES256 or EdDSA without JWT_PRIVATE_KEY_FILES")
+		logger.Error("Signing key rotation is not available", "error", err)
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		return err
+	}
+
+	db, err := openDatabase(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	store, err := newStore(db, cfg)
+	if err != nil {
+		logger.Error("Invalid hash configuration", "error", err)
+		return err
+	}
+
+	manager, err := newKeyManager(ctx, cfg, store, logger)
+	if err != nil {
+		logger.Error("Failed to create key manager", "error", err)
+		return err
+	}
+	if err := manager.Load(ctx); err != nil {
+		logger.Error("Failed to load signing keys", "error", err)
+		return err
+	}
+
+	key, err := manager.Rotate(ctx)
+	if err != nil {
+		logger.Error("Signing key rotation failed", "error", err)
+		return err
+	}
+
+	logger.Info("Signing key rotation completed", "kid", key.KID, "alg", key.Method.Alg())
+	return nil
+}
+
+// openDatabase дожидается готовности БД, открывает соединение и применяет миграции
+func openDatabase(cfg *config.Config, logger *slog.Logger) (*sql.DB, error) {
+	// Ожидание готовности БД до открытия соединения ()
+	if err := waitForDB(cfg.DatabaseURL); err != nil {
+		logger.Error("Database not ready", "error", err)
+		return nil, err
+	}
+
+	// Подключение к базе данных с таймаутом
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("postgres", cfg.DatabaseURL)
+	if err != nil {
+		logger.Error("Failed to connect to database", "error", err)
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		logger.Error("Failed to ping database", "error", err)
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err := runMigrations(cfg.DatabaseURL); err != nil {
+		logger.Error("Failed to run migrations", "error", err)
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// newStore создает хранилище с хешированием секретов клиентов, паролей и токенов по конфигурации
+func newStore(db *sql.DB, cfg *config.Config) (*storage.PostgresStore, error) {
+	secretHasher, err := hasher.New(cfg.ClientSecretHashAlg, hasher.DefaultParams())
+	if err != nil {
+		return nil, fmt.Errorf("invalid client secret hash configuration: %w", err)
+	}
+
+	passwordHasher, err := hasher.New(cfg.PasswordHashAlg, cfg.PasswordHashParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password hash configuration: %w", err)
+	}
+
+	tokenHasher := storage.NewTokenHasher([]byte(cfg.TokenHashSecret))
+	return storage.NewPostgresStore(db, secretHasher, passwordHasher, tokenHasher), nil
+}
+
+// newRateLimiter создает ограничитель частоты запросов с корзинами в памяти процесса
+// или в Postgres, общими для всех реплик
+func newRateLimiter(cfg *config.Config, store *storage.PostgresStore) (ratelimit.Limiter, error) {
+	switch cfg.RateLimitStore {
+	case "memory":
+		return ratelimit.NewMemory(), nil
+	case "postgres":
+		return ratelimit.NewShared(store), nil
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store: %s", cfg.RateLimitStore)
+	}
+}
+
+// newWebAuthn создает проверяющую сторону WebAuthn для ключей доступа. Аттестация не запрашивается:
+// сервер не ограничивает модели аутентификаторов
+func newWebAuthn(cfg *config.Config) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:                  cfg.WebAuthnRPID,
+		RPDisplayName:         cfg.WebAuthnRPName,
+		RPOrigins:             cfg.WebAuthnOrigins,
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+}
+
+// newMailer создает отправку писем по MAIL_DRIVER. Драйверы file и log предназначены для локальной разработки
+func newMailer(cfg *config.Config, logger *slog.Logger) (mailer.Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		return mailer.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case "file":
+		return mailer.NewFile(cfg.MailDir, cfg.MailFrom)
+	case "log":
+		return mailer.NewLog(logger), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail driver: %s", cfg.MailDriver)
+	}
+}
+
+// hashStoredTokens заменяет токены, сохраненные в открытом виде до миграции 016, их хешами
+func hashStoredTokens(store *storage.PostgresStore, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	hashed, err := store.HashStoredTokens(ctx)
+	if err != nil {
+		logger.Error("Failed to hash stored tokens", "error", err)
+		return err
+	}
+	if hashed > 0 {
+		logger.Info("Plaintext tokens replaced with hashes", "rows_affected", hashed)
+	}
+	return nil
+}
+
+// cleanExpired периодически удаляет истекшие записи до отмены ctx. Реплики очищают
+// одни и те же таблицы независимо, повторное удаление безопасно
+func cleanExpired(ctx context.Context, store *storage.PostgresStore, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			if err := store.CleanExpiredTokens(cleanCtx); err != nil {
+				logger.Error("Failed to clean expired records", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// loadSigningKeys выбирает источник ключей подписи: PEM файлы, общий секрет HS256
+// или ключи в БД с ротацией. Для последнего варианта возвращает менеджер ключей.
+func loadSigningKeys(ctx context.Context, cfg *config.Config, store *storage.PostgresStore, logger *slog.Logger) (jwt.KeyProvider, *jwt.KeyManager, error) {
+	if !cfg.StoredSigningKeys() {
+		keys, err := jwt.LoadKeySet(cfg.JWTPrivateKeyFiles, []byte(cfg.JWTSecret))
+		if err != nil {
+			return nil, nil, err
+		}
+		return keys, nil, nil
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	manager, err := newKeyManager(loadCtx, cfg, store, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := manager.Load(loadCtx); err != nil {
+		return nil, nil, err
+	}
+	return manager, manager, nil
+}
+
+// newKeyManager создает менеджер ключей подписи в БД, предварительно зашифровав ключи,
+// сохраненные в открытом виде. Выведенный из подписи ключ остается в JWKS на время жизни access токена
+func newKeyManager(ctx context.Context, cfg *config.Config, store *storage.PostgresStore, logger *slog.Logger) (*jwt.KeyManager, error) {
+	cipher := mfa.NewCipher(cfg.SigningKeySecret)
+	encrypted, err := store.EncryptStoredSigningKeys(ctx, cipher)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted > 0 {
+		logger.Info("Plaintext signing keys encrypted", "rows_affected", encrypted)
+	}
+	return jwt.NewKeyManager(store, cipher, cfg.JWTSigningAlg, cfg.TokenExpiration, logger)
+}
+
 func waitForDB(databaseURL string) error {
 	const maxRetries = 10
 	for i := 1; i <= maxRetries; i++ {
@@ -213,6 +552,65 @@ func runMigrations(databaseURL string) error {
 	return nil
 }
 
+// parseTrustedProxies разбирает адреса и подсети доверенных прокси
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// realIPMiddleware заменяет RemoteAddr адресом клиента из X-Forwarded-For, если запрос пришел
+// от доверенного прокси. Заголовок читается справа налево до первого недоверенного адреса,
+// поэтому клиент не может подставить произвольный IP
+func realIPMiddleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(value string) bool {
+		addr, err := netip.ParseAddr(strings.TrimSpace(value))
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, port, err := net.SplitHostPort(r.RemoteAddr)
+			if len(trusted) == 0 || err != nil || !isTrusted(host) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				ip := strings.TrimSpace(forwarded[i])
+				if ip == "" || isTrusted(ip) {
+					continue
+				}
+				if addr, err := netip.ParseAddr(ip); err == nil {
+					r.RemoteAddr = net.JoinHostPort(addr.Unmap().String(), port)
+				}
+				break
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func loggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -250,7 +648,7 @@ func metricsMiddleware(next http.Handler) http.Handler {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// PathMe API учетной записи пользователя. Доступен по access токену пользователя со scope account
+const PathMe = "/me"
+
+// scopeAccount scope API учетной записи
+const scopeAccount = "account"
+
+// errInvalidPassword текущий пароль, подтверждающий действие с учетной записью, неверен
+var errInvalidPassword = errors.New("invalid password")
+
+// RequireAccount пропускает только запросы с действующим access токеном пользователя со scope account
+func (h *Handler) RequireAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := h.authorizeBearer(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		// Токены client_credentials выдаются клиенту, а не пользователю
+		if info.Sub == "" {
+			h.writeBearerError(w, "invalid_token", "The access token is not issued to a user", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bearerContextKey{}, info)))
+	})
+}
+
+// accountUser загружает владельца access токена. При ошибке ответ уже отправлен
+func (h *Handler) accountUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID := bearerFrom(r).Sub
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Warn("Account subject not found", "sub", userID, "error", err)
+		h.writeBearerError(w, "invalid_token", "The access token subject no longer exists", http.StatusUnauthorized)
+		return nil, false
+	}
+	return user, true
+}
+
+// confirmPassword проверяет текущий пароль перед изменением учетной записи с той же защитой
+// от перебора, что и вход: неверный пароль учитывается как неудачный вход
+func (h *Handler) confirmPassword(ctx context.Context, user *models.User, password, ip string) error {
+	if err := h.guardLogin(ctx, user.Username, ip); err != nil {
+		return err
+	}
+	if _, err := h.store.ValidateUser(ctx, user.Username, password); err != nil {
+		h.loginFailed(ctx, user.Username, ip)
+		return errInvalidPassword
+	}
+	return nil
+}
+
+// writeConfirmPasswordError отправляет ответ на ошибку confirmPassword
+func (h *Handler) writeConfirmPasswordError(w http.ResponseWriter, user *models.User, err error) {
+	switch {
+	case errors.Is(err, errLoginLocked):
+		setLoginRetryAfter(w, err)
+		h.writeErrorResponse(w, "access_denied", "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+	case errors.Is(err, errInvalidPassword):
+		h.logger.Warn("Account password confirmation failed", "user_id", user.ID)
+		h.writeErrorResponse(w, "access_denied", "Invalid password", http.StatusForbidden)
+	default:
+		h.logger.Error("Failed to confirm password", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to confirm password", http.StatusInternalServerError)
+	}
+}
+
+// profile профиль пользователя без хеша пароля
+func profile(user *models.User) models.Profile {
+	return models.Profile{
+		ID:                  user.ID,
+		Username:            user.Username,
+		Name:                user.Name,
+		GivenName:           user.GivenName,
+		FamilyName:          user.FamilyName,
+		Nickname:            user.Nickname,
+		Picture:             user.Picture,
+		Locale:              user.Locale,
+		Zoneinfo:            user.Zoneinfo,
+		Email:               user.Email,
+		EmailVerified:       user.EmailVerified,
+		PhoneNumber:         user.PhoneNumber,
+		PhoneNumberVerified: user.PhoneNumberVerified,
+		Address:             user.Address,
+		CreatedAt:           user.CreatedAt,
+		UpdatedAt:           user.UpdatedAt,
+	}
+}
+
+// GetProfile godoc
+// @Summary Профиль пользователя
+// @Description Профиль владельца access токена
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.Profile
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Router /me [get]
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+	h.writeJSONResponse(w, profile(user), http.StatusOK)
+}
+
+// UpdateProfile godoc
+// @Summary Изменение профиля
+// @Description Меняет переданные поля профиля. Новый адрес электронной почты или телефон становится неподтвержденным, на новый адрес отправляется письмо подтверждения
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ProfileUpdate true "Изменяемые поля"
+// @Success 200 {object} models.Profile
+// @Failure 400 {object} map[string]string "Неверный формат запроса или адрес"
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Failure 409 {object} map[string]string "Адрес уже зарегистрирован"
+// @Router /me [patch]
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	previousEmail := user.Email
+	for field, value := range map[*string]*string{
+		&user.Name:        req.Name,
+		&user.GivenName:   req.GivenName,
+		&user.FamilyName:  req.FamilyName,
+		&user.Nickname:    req.Nickname,
+		&user.Picture:     req.Picture,
+		&user.Locale:      req.Locale,
+		&user.Zoneinfo:    req.Zoneinfo,
+		&user.PhoneNumber: req.PhoneNumber,
+	} {
+		if value != nil {
+			*field = *value
+		}
+	}
+	if req.Address != nil {
+		user.Address = req.Address
+	}
+	if req.Email != nil {
+		email, ok := normalizeEmail(*req.Email)
+		if !ok {
+			h.writeErrorResponse(w, "invalid_request", "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		user.Email = email
+	}
+
+	if err := h.store.UpdateUserProfile(ctx, user); err != nil {
+		if errors.Is(err, storage.ErrEmailTaken) {
+			h.writeErrorResponse(w, "invalid_request", "Email address is already registered", http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to update profile", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	updated, err := h.store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+	if updated.Email != "" && !strings.EqualFold(updated.Email, previousEmail) {
+		h.sendEmailVerification(updated)
+	}
+
+	h.logger.Info("Profile updated", "user_id", user.ID, "client_id", bearerFrom(r).ClientID)
+	h.writeJSONResponse(w, profile(updated), http.StatusOK)
+}
+
+// ChangePassword godoc
+// @Summary Смена пароля
+// @Description Меняет пароль после проверки текущего. Сессии страницы входа завершаются, токены пользователя отзываются, кроме токенов гранта, которым выполнен запрос. Неверный текущий пароль учитывается в защите от перебора
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.PasswordChangeRequest true "Текущий и новый пароль"
+// @Success 204 "Пароль изменен"
+// @Failure 400 {object} map[string]string "Пароль не задан"
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Неверный текущий пароль или нет scope account"
+// @Failure 429 {object} map[string]string "Вход временно заблокирован"
+// @Router /me/password [post]
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		h.writeErrorResponse(w, "invalid_request", "Current and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.confirmPassword(ctx, user, req.CurrentPassword, clientIP(r)); err != nil {
+		h.writeConfirmPasswordError(w, user, err)
+		return
+	}
+
+	token, _ := bearerToken(r)
+	if err := h.store.ChangePassword(ctx, user.ID, req.NewPassword, token); err != nil {
+		h.logger.Error("Failed to change password", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	h.loginSucceeded(ctx, user)
+
+	h.logger.Info("Password changed", "user_id", user.ID, "client_id", bearerFrom(r).ClientID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAccountTokens godoc
+// @Summary Выданные токены
+// @Description Действующие гранты пользователя: по одной записи на вход в клиент с access и refresh токенами. Поле current отмечает грант токена запроса
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.AccountToken
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Router /me/tokens [get]
+func (h *Handler) ListAccountTokens(w http.ResponseWriter, r *http.Request) {
+	userID := bearerFrom(r).Sub
+	token, _ := bearerToken(r)
+
+	tokens, err := h.store.ListUserTokens(r.Context(), userID, token)
+	if err != nil {
+		h.logger.Error("Failed to list user tokens", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+	h.writeJSONResponse(w, tokens, http.StatusOK)
+}
+
+// RevokeAccountToken godoc
+// @Summary Отзыв гранта
+// @Description Отзывает access и refresh токены гранта пользователя
+// @Tags account
+// @Security BearerAuth
+// @Param id path string true "Идентификатор гранта из списка токенов"
+// @Success 204 "Токены отозваны"
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Failure 404 {object} map[string]string "Грант не найден"
+// @Router /me/tokens/{id} [delete]
+func (h *Handler) RevokeAccountToken(w http.ResponseWriter, r *http.Request) {
+	userID := bearerFrom(r).Sub
+	id := chi.URLParam(r, "id")
+
+	if _, err := uuid.Parse(id); err != nil {
+		h.writeErrorResponse(w, "not_found", "Token not found", http.StatusNotFound)
+		return
+	}
+
+	revoked, err := h.store.RevokeUserTokenFamily(r.Context(), userID, id)
+	if err != nil {
+		h.logger.Error("Failed to revoke user tokens", "user_id", userID, "family_id", id, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to revoke tokens", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		h.writeErrorResponse(w, "not_found", "Token not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("User tokens revoked", "user_id", userID, "family_id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAccountSessions godoc
+// @Summary Сессии страницы входа
+// @Description Действующие сессии пользователя в браузерах
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.AccountSession
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Router /me/sessions [get]
+func (h *Handler) ListAccountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := bearerFrom(r).Sub
+
+	sessions, err := h.store.ListUserSessions(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list sessions", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+	h.writeJSONResponse(w, sessions, http.StatusOK)
+}
+
+// RevokeAccountSession godoc
+// @Summary Завершение сессии
+// @Description Завершает сессию страницы входа. Токены, выданные клиентам во время сессии, не отзываются
+// @Tags account
+// @Security BearerAuth
+// @Param id path string true "Идентификатор сессии из списка сессий"
+// @Success 204 "Сессия завершена"
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope account"
+// @Failure 404 {object} map[string]string "Сессия не найдена"
+// @Router /me/sessions/{id} [delete]
+func (h *Handler) RevokeAccountSession(w http.ResponseWriter, r *http.Request) {
+	userID := bearerFrom(r).Sub
+
+	deleted, err := h.store.DeleteUserSession(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.logger.Error("Failed to delete session", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to delete session", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		h.writeErrorResponse(w, "not_found", "Session not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Session revoked", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAccount godoc
+// @Summary Удаление учетной записи
+// @Description Удаляет учетную запись после проверки пароля вместе с сессиями, согласиями, вторым фактором и ключами доступа. Все выданные пользователю токены отзываются
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param request body models.AccountDeleteRequest true "Текущий пароль"
+// @Success 204 "Учетная запись удалена"
+// @Failure 400 {object} map[string]string "Пароль не задан"
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Неверный пароль или нет scope account"
+// @Failure 429 {object} map[string]string "Вход временно заблокирован"
+// @Router /me [delete]
+func (h *Handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, ok := h.accountUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.AccountDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		h.writeErrorResponse(w, "invalid_request", "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.confirmPassword(ctx, user, req.Password, clientIP(r)); err != nil {
+		h.writeConfirmPasswordError(w, user, err)
+		return
+	}
+
+	if _, err := h.store.DeleteUser(ctx, user.ID); err != nil {
+		h.logger.Error("Failed to delete user", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	h.loginSucceeded(ctx, user)
+
+	h.logger.Info("Account deleted",
+		"event", "account_delete",
+		"user_id", user.ID,
+		"username", user.Username,
+		"client_id", bearerFrom(r).ClientID,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PathAdmin административный API. Доступен по access токену client_credentials со scope admin
+const PathAdmin = "/admin"
+
+// scopeAdmin ограниченный scope административного API
+const scopeAdmin = "admin"
+
+// bearerContextKey ключ контекста запроса с результатом проверки access токена
+type bearerContextKey struct{}
+
+// bearerFrom данные access токена, проверенного middleware
+func bearerFrom(r *http.Request) models.IntrospectResponse {
+	info, _ := r.Context().Value(bearerContextKey{}).(models.IntrospectResponse)
+	return info
+}
+
+// RequireAdmin пропускает только запросы с действующим access токеном client_credentials со scope admin.
+// У пользователей нет ролей, поэтому токен, выданный пользователю через клиент со scope admin,
+// прав администратора не дает
+func (h *Handler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := h.authorizeBearer(w, r, scopeAdmin)
+		if !ok {
+			return
+		}
+		if info.Sub != "" {
+			h.logger.Warn("Admin API called with a user token", "sub", info.Sub, "client_id", info.ClientID)
+			h.writeBearerError(w, "insufficient_scope", "The admin API requires a client_credentials access token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bearerContextKey{}, info)))
+	})
+}
+
+// UnlockLogin godoc
+// @Summary Снятие блокировки входа
+// @Description Сбрасывает счетчик неудачных входов и блокировку имени пользователя или IP адреса
+// @Tags admin
+// @Security BearerAuth
+// @Param subject_type path string true "Тип субъекта" Enums(user, ip)
+// @Param subject path string true "Имя пользователя или IP адрес"
+// @Success 204 "Блокировка снята"
+// @Failure 400 {object} map[string]string "Неизвестный тип субъекта"
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Failure 404 {object} map[string]string "Неудачных входов не найдено"
+// @Router /admin/lockouts/{subject_type}/{subject} [delete]
+func (h *Handler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	subjectType := chi.URLParam(r, "subject_type")
+	subject := chi.URLParam(r, "subject")
+
+	if subjectType != storage.LoginSubjectUser && subjectType != storage.LoginSubjectIP {
+		h.writeErrorResponse(w, "invalid_request", "subject_type must be user or ip", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.store.ResetLoginFailures(r.Context(), subjectType, subject)
+	if err != nil {
+		h.logger.Error("Failed to unlock login", "subject_type", subjectType, "subject", subject, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to unlock login", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.writeErrorResponse(w, "not_found", "No login failures recorded", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Login unlocked",
+		"event", "login_unlock",
+		"subject_type", subjectType,
+		"subject", subject,
+		"admin", bearerFrom(r).Sub,
+		"admin_client_id", bearerFrom(r).ClientID,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
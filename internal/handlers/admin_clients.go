@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Размер страницы списка клиентов
+const (
+	defaultClientsLimit = 50
+	maxClientsLimit     = 200
+)
+
+// maxClientSecretGraceFactor во сколько раз grace_period_seconds может превышать CLIENT_SECRET_GRACE_HOURS
+const maxClientSecretGraceFactor = 7
+
+// clientDetails клиент для административного API без хешей секретов
+func clientDetails(client *models.Client) models.ClientDetails {
+	details := models.ClientDetails{
+		ClientID:                  client.ID,
+		Domain:                    client.Domain,
+		UserID:                    client.UserID,
+		Public:                    client.Public,
+		RequirePKCE:               client.RequirePKCE,
+		RedirectURIs:              client.RedirectURIs,
+		GrantTypes:                client.GrantTypes,
+		ResponseTypes:             client.ResponseTypes,
+		Scope:                     strings.Join(client.AllowedScopes, " "),
+		UserinfoSignedResponseAlg: client.UserinfoSignedResponseAlg,
+		CreatedAt:                 client.CreatedAt,
+		UpdatedAt:                 client.UpdatedAt,
+		DeletedAt:                 client.DeletedAt,
+	}
+	// Истекший прежний секрет уже не принимается, и показывать срок незачем
+	if client.PreviousSecretExpiresAt != nil && time.Now().Before(*client.PreviousSecretExpiresAt) {
+		details.PreviousSecretExpiresAt = client.PreviousSecretExpiresAt
+	}
+	return details
+}
+
+// clientFilter разбирает параметры выборки списка клиентов
+func clientFilter(r *http.Request) (models.ClientFilter, error) {
+	query := r.URL.Query()
+	filter := models.ClientFilter{
+		Domain:    query.Get("domain"),
+		UserID:    query.Get("user_id"),
+		GrantType: query.Get("grant_type"),
+		Limit:     defaultClientsLimit,
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxClientsLimit {
+			return filter, errors.New("limit must be between 1 and " + strconv.Itoa(maxClientsLimit))
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, errors.New("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	if value := query.Get("is_public"); value != "" {
+		public, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("is_public must be true or false")
+		}
+		filter.Public = &public
+	}
+	if value := query.Get("include_deleted"); value != "" {
+		includeDeleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("include_deleted must be true or false")
+		}
+		filter.IncludeDeleted = includeDeleted
+	}
+	return filter, nil
+}
+
+// ListClients godoc
+// @Summary Список клиентов
+// @Description Страница клиентов, начиная с новых. Удаленные клиенты показываются только с include_deleted=true
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Размер страницы, от 1 до 200" default(50)
+// @Param offset query int false "Число пропускаемых клиентов" default(0)
+// @Param domain query string false "Часть домена без учета регистра"
+// @Param user_id query string false "Владелец клиента"
+// @Param grant_type query string false "Разрешенный клиенту grant type"
+// @Param is_public query bool false "Только публичные или только конфиденциальные клиенты"
+// @Param include_deleted query bool false "Включить удаленных клиентов"
+// @Success 200 {object} models.ClientList
+// @Failure 400 {object} map[string]string "Неверные параметры выборки"
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Router /admin/clients [get]
+func (h *Handler) ListClients(w http.ResponseWriter, r *http.Request) {
+	filter, err := clientFilter(r)
+	if err != nil {
+		h.writeErrorResponse(w, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clients, total, err := h.store.ListClients(r.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to list clients", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to list clients", http.StatusInternalServerError)
+		return
+	}
+
+	list := models.ClientList{
+		Clients: make([]models.ClientDetails, 0, len(clients)),
+		Total:   total,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	}
+	for i := range clients {
+		list.Clients = append(list.Clients, clientDetails(&clients[i]))
+	}
+	h.writeJSONResponse(w, list, http.StatusOK)
+}
+
+// GetClient godoc
+// @Summary Клиент
+// @Description Клиент по client_id, в том числе удаленный
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "client_id"
+// @Success 200 {object} models.ClientDetails
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Failure 404 {object} map[string]string "Клиент не найден"
+// @Router /admin/clients/{id} [get]
+func (h *Handler) GetClient(w http.ResponseWriter, r *http.Request) {
+	client, err := h.store.GetClientWithDeleted(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+	h.writeJSONResponse(w, clientDetails(client), http.StatusOK)
+}
+
+// UpdateClient godoc
+// @Summary Изменение клиента
+// @Description Меняет переданные поля клиента. Проверки те же, что при регистрации, но администратор может назначить restricted scope. Выданные токены не отзываются
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "client_id"
+// @Param request body models.ClientUpdate true "Изменяемые поля"
+// @Success 200 {object} models.ClientDetails
+// @Failure 400 {object} map[string]string "Неверный формат запроса или метаданные клиента"
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Failure 404 {object} map[string]string "Клиент не найден или удален"
+// @Router /admin/clients/{id} [patch]
+func (h *Handler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	client, err := h.store.GetClient(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+
+	var req models.ClientUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.Domain != nil {
+		client.Domain = *req.Domain
+	}
+
+	if req.RedirectURIs != nil {
+		for _, redirectURI := range *req.RedirectURIs {
+			if err := validateRedirectURI(redirectURI); err != nil {
+				h.writeErrorResponse(w, "invalid_redirect_uri", err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		client.RedirectURIs = *req.RedirectURIs
+	}
+
+	if req.GrantTypes != nil || req.ResponseTypes != nil {
+		grantTypes := client.GrantTypes
+		if req.GrantTypes != nil {
+			grantTypes = *req.GrantTypes
+		}
+		// При смене grant types без response types последние выводятся заново
+		var responseTypes []string
+		if req.ResponseTypes != nil {
+			responseTypes = *req.ResponseTypes
+		} else if req.GrantTypes == nil {
+			responseTypes = client.ResponseTypes
+		}
+
+		client.GrantTypes, client.ResponseTypes, err = h.clientGrants(client.Public, grantTypes, responseTypes)
+		if err != nil {
+			h.writeErrorResponse(w, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Scope != nil {
+		registered, err := h.store.ListScopes(ctx)
+		if err != nil {
+			h.logger.Error("Failed to list scopes", "error", err)
+			h.writeErrorResponse(w, "server_error", "Failed to update client", http.StatusInternalServerError)
+			return
+		}
+		if client.AllowedScopes, err = assignedScopes(registered, *req.Scope); err != nil {
+			h.writeErrorResponse(w, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.RequirePKCE != nil {
+		// Публичные клиенты не аутентифицируются, поэтому PKCE для них обязателен всегда
+		if client.Public && !*req.RequirePKCE {
+			h.writeErrorResponse(w, "invalid_client_metadata", "Public clients always require PKCE", http.StatusBadRequest)
+			return
+		}
+		client.RequirePKCE = *req.RequirePKCE
+	}
+
+	if req.UserinfoSignedResponseAlg != nil {
+		alg := *req.UserinfoSignedResponseAlg
+		if alg != "" && !slices.Contains(h.userInfoSigningAlgorithms(), alg) {
+			h.writeErrorResponse(w, "invalid_client_metadata", "Unsupported userinfo_signed_response_alg", http.StatusBadRequest)
+			return
+		}
+		client.UserinfoSignedResponseAlg = alg
+	}
+
+	updated, err := h.store.UpdateClient(ctx, client)
+	if err != nil {
+		h.logger.Error("Failed to update client", "client_id", client.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to update client", http.StatusInternalServerError)
+		return
+	}
+	if !updated {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+
+	clientID := client.ID
+	if client, err = h.store.GetClient(ctx, clientID); err != nil {
+		h.logger.Error("Failed to get client", "client_id", clientID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to update client", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Client updated",
+		"event", "client_update",
+		"client_id", client.ID,
+		"admin", bearerFrom(r).Sub,
+		"admin_client_id", bearerFrom(r).ClientID,
+	)
+	h.writeJSONResponse(w, clientDetails(client), http.StatusOK)
+}
+
+// DeleteClient godoc
+// @Summary Удаление клиента
+// @Description Мягко удаляет клиента: запись остается для аудита, клиент больше не аутентифицируется. Согласия пользователей и неиспользованные authorization codes удаляются, выданные клиенту токены отзываются
+// @Tags admin
+// @Security BearerAuth
+// @Param id path string true "client_id"
+// @Success 204 "Клиент удален"
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Failure 404 {object} map[string]string "Клиент не найден или уже удален"
+// @Router /admin/clients/{id} [delete]
+func (h *Handler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	clientID := chi.URLParam(r, "id")
+
+	deleted, err := h.store.DeleteClient(r.Context(), clientID)
+	if err != nil {
+		h.logger.Error("Failed to delete client", "client_id", clientID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to delete client", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Client deleted",
+		"event", "client_delete",
+		"client_id", clientID,
+		"admin", bearerFrom(r).Sub,
+		"admin_client_id", bearerFrom(r).ClientID,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateClientSecret godoc
+// @Summary Ротация секрета клиента
+// @Description Выдает конфиденциальному клиенту новый секрет. Прежний секрет принимается еще grace_period_seconds, по умолчанию CLIENT_SECRET_GRACE_HOURS, не более семикратного CLIENT_SECRET_GRACE_HOURS. Новый секрет показывается один раз
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "client_id"
+// @Param request body models.ClientSecretRotation false "Период, в течение которого принимается прежний секрет"
+// @Success 200 {object} models.ClientSecret
+// @Failure 400 {object} map[string]string "Публичный клиент, неверный формат запроса или слишком большой grace_period_seconds"
+// @Failure 401 {object} map[string]string "Требуется access токен"
+// @Failure 403 {object} map[string]string "Нет scope admin или токен выдан пользователю"
+// @Failure 404 {object} map[string]string "Клиент не найден или удален"
+// @Router /admin/clients/{id}/secret [post]
+func (h *Handler) RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.ClientSecretRotation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	grace := h.config.ClientSecretGrace
+	if req.GracePeriodSeconds != nil {
+		if *req.GracePeriodSeconds < 0 {
+			h.writeErrorResponse(w, "invalid_request", "grace_period_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		// Сравнение в секундах: большое значение переполнило бы time.Duration
+		maxGrace := int64(h.config.ClientSecretGrace / time.Second * maxClientSecretGraceFactor)
+		if *req.GracePeriodSeconds > maxGrace {
+			h.writeErrorResponse(w, "invalid_request", "grace_period_seconds must not exceed "+strconv.FormatInt(maxGrace, 10), http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	client, err := h.store.GetClient(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+	if client.Public {
+		h.writeErrorResponse(w, "invalid_request", "Public clients have no secret", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		h.logger.Error("Failed to generate client secret", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to rotate client secret", http.StatusInternalServerError)
+		return
+	}
+
+	previousExpiresAt, rotated, err := h.store.RotateClientSecret(ctx, client.ID, secret, grace)
+	if err != nil {
+		h.logger.Error("Failed to rotate client secret", "client_id", client.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to rotate client secret", http.StatusInternalServerError)
+		return
+	}
+	if !rotated {
+		h.writeErrorResponse(w, "not_found", "Client not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Client secret rotated",
+		"event", "client_secret_rotate",
+		"client_id", client.ID,
+		"grace_period", grace,
+		"admin", bearerFrom(r).Sub,
+		"admin_client_id", bearerFrom(r).ClientID,
+	)
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.ClientSecret{
+		ClientID:                client.ID,
+		ClientSecret:            secret,
+		PreviousSecretExpiresAt: previousExpiresAt,
+	}, http.StatusOK)
+}
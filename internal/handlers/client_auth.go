@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"go_oauth2_server/internal/models"
+
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/server"
+)
+
+var errInvalidClient = errors.New("client authentication failed")
+
+// authenticateClient аутентифицирует клиента по HTTP Basic (RFC 6749 §2.3.1)
+// или по параметрам client_id/client_secret в теле запроса.
+// Публичные клиенты идентифицируются только по client_id.
+func (h *Handler) authenticateClient(r *http.Request) (*models.Client, error) {
+	clientID, clientSecret, ok, err := basicClientCredentials(r)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	if !ok {
+		clientID = r.PostFormValue("client_id")
+		clientSecret = r.PostFormValue("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := h.store.GetClient(r.Context(), clientID)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	client, err = h.store.ValidateClient(r.Context(), clientID, clientSecret)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// basicClientCredentials извлекает учетные данные клиента из заголовка Basic
+func basicClientCredentials(r *http.Request) (clientID, clientSecret string, ok bool, err error) {
+	clientID, clientSecret, ok = r.BasicAuth()
+	if !ok {
+		return "", "", false, nil
+	}
+
+	// В Basic значения передаются в form-urlencoded виде
+	if clientID, err = url.QueryUnescape(clientID); err != nil {
+		return "", "", true, errInvalidClient
+	}
+	if clientSecret, err = url.QueryUnescape(clientSecret); err != nil {
+		return "", "", true, errInvalidClient
+	}
+	return clientID, clientSecret, true, nil
+}
+
+// clientInfoHandler извлекает учетные данные клиента на /token:
+// client_secret_basic или client_secret_post (RFC 6749 §2.3.1)
+func clientInfoHandler(r *http.Request) (string, string, error) {
+	clientID, clientSecret, ok, err := basicClientCredentials(r)
+	if err != nil {
+		return "", "", oauthErrors.ErrInvalidClient
+	}
+	if ok {
+		return clientID, clientSecret, nil
+	}
+	return server.ClientFormHandler(r)
+}
+
+// requestClientID client_id из Basic или из параметров запроса
+func requestClientID(r *http.Request) string {
+	if clientID, _, ok, err := basicClientCredentials(r); ok && err == nil {
+		return clientID
+	}
+	return r.FormValue("client_id")
+}
+
+// writeInvalidClient отправляет ответ invalid_client с запросом Basic аутентификации (RFC 6749 §5.2)
+func (h *Handler) writeInvalidClient(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	h.writeErrorResponse(w, "invalid_client", "Client authentication failed", http.StatusUnauthorized)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// grantTypeImplicit имя implicit grant в метаданных клиента (RFC 7591 §2). Библиотека
+// обозначает его внутренним значением oauth2.Implicit
+const grantTypeImplicit = "implicit"
+
+// grantTypeName имя grant type в метаданных клиента
+func grantTypeName(grant oauth2.GrantType) string {
+	if grant == oauth2.Implicit {
+		return grantTypeImplicit
+	}
+	return grant.String()
+}
+
+// grantTypeForResponseType grant type, который клиент должен иметь для response_type (RFC 7591 §2.1)
+func grantTypeForResponseType(responseType oauth2.ResponseType) string {
+	if responseType == oauth2.Token {
+		return grantTypeImplicit
+	}
+	return oauth2.AuthorizationCode.String()
+}
+
+// supportedGrantTypes grant types, которые можно выдать клиенту при регистрации
+func (h *Handler) supportedGrantTypes() []string {
+	grantTypes := make([]string, 0, len(h.srv.Config.AllowedGrantTypes)+1)
+	for _, gt := range h.srv.Config.AllowedGrantTypes {
+		grantTypes = append(grantTypes, gt.String())
+	}
+	// Implicit выдается через /authorize с response_type=token и не входит в grant types /token
+	if h.srv.CheckResponseType(oauth2.Token) {
+		grantTypes = append(grantTypes, grantTypeImplicit)
+	}
+	return grantTypes
+}
+
+// clientGrants проверяет grant_types и response_types регистрируемого клиента и подставляет
+// значения по умолчанию: grant types authorization_code и refresh_token, response types по grant types
+func (h *Handler) clientGrants(public bool, grantTypes, responseTypes []string) ([]string, []string, error) {
+	if len(grantTypes) == 0 {
+		grantTypes = []string{oauth2.AuthorizationCode.String(), oauth2.Refreshing.String()}
+	}
+
+	supported := h.supportedGrantTypes()
+	for _, gt := range grantTypes {
+		if !slices.Contains(supported, gt) {
+			return nil, nil, errors.New("unsupported grant type: " + gt)
+		}
+	}
+	// Публичный клиент не может аутентифицироваться и действовать от своего имени
+	if public && slices.Contains(grantTypes, oauth2.ClientCredentials.String()) {
+		return nil, nil, errors.New("public clients cannot use client_credentials")
+	}
+
+	if len(responseTypes) == 0 {
+		for _, rt := range []oauth2.ResponseType{oauth2.Code, oauth2.Token} {
+			if slices.Contains(grantTypes, grantTypeForResponseType(rt)) {
+				responseTypes = append(responseTypes, rt.String())
+			}
+		}
+	}
+
+	for _, rt := range responseTypes {
+		responseType := oauth2.ResponseType(rt)
+		if !h.srv.CheckResponseType(responseType) {
+			return nil, nil, errors.New("unsupported response type: " + rt)
+		}
+		if !slices.Contains(grantTypes, grantTypeForResponseType(responseType)) {
+			return nil, nil, errors.New("response type " + rt + " requires grant type " + grantTypeForResponseType(responseType))
+		}
+	}
+
+	return grantTypes, responseTypes, nil
+}
+
+// clientAuthorized проверяет, что клиент зарегистрирован для grant type
+func (h *Handler) clientAuthorized(clientID string, grant oauth2.GrantType) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := h.store.GetClient(ctx, clientID)
+	if err != nil {
+		return false, oauthErrors.ErrInvalidClient
+	}
+
+	if !slices.Contains(client.GrantTypes, grantTypeName(grant)) {
+		h.logger.Warn("Client is not authorized for grant type", "client_id", clientID, "grant_type", grant)
+		return false, nil
+	}
+	return true, nil
+}
+
+// clientScopeAllowed проверяет запрос клиента перед выдачей кода или токена и сужает scope
+// до разрешенных клиенту. Для запроса авторизации клиент должен быть зарегистрирован для его response_type
+func (h *Handler) clientScopeAllowed(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	client, err := h.store.GetClient(ctx, tgr.ClientID)
+	if err != nil {
+		return false, oauthErrors.ErrInvalidClient
+	}
+
+	if tgr.Request != nil {
+		if responseType := tgr.Request.FormValue("response_type"); responseType != "" {
+			if err := h.checkResponseType(client, oauth2.ResponseType(responseType)); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	scopes, err := h.grantScope(ctx, client, tgr.Scope)
+	if err != nil {
+		return false, err
+	}
+	tgr.Scope = scopeString(scopes)
+	return true, nil
+}
+
+// checkResponseType проверяет, что клиент зарегистрирован для response_type и соответствующего grant type
+func (h *Handler) checkResponseType(client *models.Client, responseType oauth2.ResponseType) error {
+	if !slices.Contains(client.ResponseTypes, responseType.String()) ||
+		!slices.Contains(client.GrantTypes, grantTypeForResponseType(responseType)) {
+		h.logger.Warn("Client is not authorized for response type", "client_id", client.ID, "response_type", responseType)
+		return oauthErrors.ErrUnauthorizedClient
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// PathConsents согласия текущего пользователя
+const PathConsents = "/consents"
+
+// errConsentRequired запрос с prompt=none без согласия на запрошенные scope
+var errConsentRequired = errors.New("consent_required")
+
+// consentApprove решение пользователя на экране согласия, разрешающее доступ. Любое другое
+// значение поля consent считается отказом
+const consentApprove = "approve"
+
+// consentPage данные шаблона экрана согласия
+type consentPage struct {
+	Title     string
+	Error     string
+	Client    string
+	Scopes    []models.Scope
+	Action    string
+	CSRFToken string
+}
+
+// authorizeConsent проверяет согласие пользователя на scope, которые получит клиент.
+// Если ранее выданное согласие покрывает запрос, экран не показывается.
+func (h *Handler) authorizeConsent(w http.ResponseWriter, r *http.Request, userID string, client *models.Client, scopes []models.Scope) (string, error) {
+	scope := scopeString(scopes)
+
+	consent, err := h.store.GetConsent(r.Context(), userID, client.ID)
+	if err != nil {
+		h.logger.Error("Failed to load consent", "user_id", userID, "client_id", client.ID, "error", err)
+		return "", err
+	}
+
+	if r.Method == http.MethodPost {
+		if decision := r.PostFormValue("consent"); decision != "" {
+			return h.handleConsentDecision(w, r, userID, client, scopes, consent, decision)
+		}
+	}
+
+	prompt := strings.Fields(r.FormValue("prompt"))
+	if consentCovers(consent, scope) && !slices.Contains(prompt, "consent") {
+		return userID, nil
+	}
+
+	if slices.Contains(prompt, "none") {
+		return "", errConsentRequired
+	}
+
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "consent_required", "User consent is required", http.StatusForbidden)
+		return "", nil
+	}
+
+	h.renderConsent(w, r, consentPage{}, client, scopes, http.StatusOK)
+	return "", nil
+}
+
+// handleConsentDecision обрабатывает ответ пользователя на экране согласия
+func (h *Handler) handleConsentDecision(w http.ResponseWriter, r *http.Request, userID string, client *models.Client, scopes []models.Scope, consent *models.Consent, decision string) (string, error) {
+	scope := scopeString(scopes)
+
+	if !h.validCSRFToken(r) {
+		h.logger.Warn("Consent CSRF token mismatch", "user_id", userID, "ip", clientIP(r))
+		page := consentPage{Error: "Срок действия формы истек, попробуйте еще раз"}
+		h.renderConsent(w, r, page, client, scopes, http.StatusForbidden)
+		return "", nil
+	}
+
+	if decision != consentApprove {
+		h.logger.Info("Consent denied", "user_id", userID, "client_id", client.ID, "scope", scope)
+		return "", oauthErrors.ErrAccessDenied
+	}
+
+	// Новые scope добавляются к ранее выданным
+	granted := strings.Fields(scope)
+	if consent != nil {
+		for _, name := range strings.Fields(consent.Scope) {
+			if !slices.Contains(granted, name) {
+				granted = append(granted, name)
+			}
+		}
+	}
+
+	err := h.store.SaveConsent(r.Context(), &models.Consent{
+		UserID:   userID,
+		ClientID: client.ID,
+		Scope:    strings.Join(granted, " "),
+	})
+	if err != nil {
+		h.logger.Error("Failed to save consent", "user_id", userID, "client_id", client.ID, "error", err)
+		return "", err
+	}
+
+	h.logger.Info("Consent granted", "user_id", userID, "client_id", client.ID, "scope", scope)
+	return userID, nil
+}
+
+// renderConsent отображает экран согласия. Форма отправляется на исходный адрес /authorize
+func (h *Handler) renderConsent(w http.ResponseWriter, r *http.Request, page consentPage, client *models.Client, scopes []models.Scope, statusCode int) {
+	token, err := h.csrfToken(w, r)
+	if err != nil {
+		h.logger.Error("Failed to generate CSRF token", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render consent page", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Запрос доступа"
+	page.Client = client.Domain
+	page.Action = r.URL.RequestURI()
+	page.CSRFToken = token
+	page.Scopes = scopes
+
+	h.renderTemplate(w, "consent.html", page, statusCode)
+}
+
+// consentCovers проверяет, что согласие включает все запрошенные scope
+func consentCovers(consent *models.Consent, scope string) bool {
+	if consent == nil {
+		return false
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, name := range strings.Fields(scope) {
+		if !slices.Contains(granted, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListConsents godoc
+// @Summary Согласия пользователя
+// @Description Клиенты, которым текущий пользователь выдал доступ. Требуется сессия страницы входа
+// @Tags consents
+// @Produce json
+// @Success 200 {array} models.Consent
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Router /consents [get]
+func (h *Handler) ListConsents(w http.ResponseWriter, r *http.Request) {
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	consents, err := h.store.ListConsents(r.Context(), session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to list consents", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to list consents", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, consents, http.StatusOK)
+}
+
+// RevokeConsent godoc
+// @Summary Отзыв согласия
+// @Description Отзывает согласие текущего пользователя и все токены, выданные клиенту от его имени
+// @Tags consents
+// @Param client_id path string true "Идентификатор клиента"
+// @Success 204 "Согласие отозвано"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 404 {object} map[string]string "Согласие не найдено"
+// @Router /consents/{client_id} [delete]
+func (h *Handler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	clientID := chi.URLParam(r, "client_id")
+	found, err := h.store.DeleteConsent(r.Context(), session.UserID, clientID)
+	if err != nil {
+		h.logger.Error("Failed to withdraw consent", "user_id", session.UserID, "client_id", clientID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to withdraw consent", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		h.writeErrorResponse(w, "not_found", "Consent not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Consent withdrawn", "user_id", session.UserID, "client_id", clientID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/mailer"
+	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Маршруты подтверждения адреса электронной почты и сброса пароля
+const (
+	// PathEmailVerification повторная отправка письма подтверждения адреса
+	PathEmailVerification = "/email/verification"
+	// PathEmailVerify ссылка подтверждения адреса из письма
+	PathEmailVerify = "/email/verify"
+	// PathPasswordForgot запрос письма сброса пароля
+	PathPasswordForgot = "/password/forgot"
+	// PathPasswordReset ссылка сброса пароля из письма: форма и установка нового пароля
+	PathPasswordReset = "/password/reset"
+)
+
+const (
+	// userTokenBytes энтропия токенов в ссылках из писем
+	userTokenBytes = 32
+	// mailCooldown минимальный интервал между письмами одного назначения одному пользователю
+	mailCooldown = time.Minute
+	// mailTimeout время на создание токена и отправку письма
+	mailTimeout = 30 * time.Second
+	// maxEmailLength длина столбца users.email
+	maxEmailLength = 255
+)
+
+// errEmailNotVerified вход отклонен: включен REQUIRE_VERIFIED_EMAIL, а адрес пользователя не подтвержден
+var errEmailNotVerified = errors.New("email address is not verified")
+
+var mailSentTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "oauth2_mail_sent_total",
+		Help: "Total number of emails sent to users",
+	},
+	[]string{"purpose", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(mailSentTotal)
+}
+
+// messagePage данные шаблона страницы с результатом действия по ссылке из письма
+type messagePage struct {
+	Title  string
+	Error  string
+	Notice string
+}
+
+// passwordResetPage данные шаблона формы нового пароля
+type passwordResetPage struct {
+	Title     string
+	Error     string
+	Token     string
+	CSRFToken string
+}
+
+// normalizeEmail проверяет адрес электронной почты. Допускается только адрес без имени получателя,
+// пустой адрес остается пустым
+func normalizeEmail(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", true
+	}
+	if len(email) > maxEmailLength {
+		return "", false
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", false
+	}
+	return email, true
+}
+
+// sendUserToken создает одноразовый токен и отправляет письмо со ссылкой path?token=... в фоне:
+// время ответа не зависит от того, есть ли пользователь с адресом и было ли отправлено письмо
+func (h *Handler) sendUserToken(purpose string, user *models.User, ttl time.Duration, path string, compose func(link, expires string) mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		token, err := randomToken(userTokenBytes)
+		if err != nil {
+			h.logger.Error("Failed to generate user token", "user_id", user.ID, "purpose", purpose, "error", err)
+			return
+		}
+
+		created, err := h.store.CreateUserToken(ctx, purpose, user.ID, user.Email, token, ttl, mailCooldown)
+		if err != nil {
+			h.logger.Error("Failed to create user token", "user_id", user.ID, "purpose", purpose, "error", err)
+			return
+		}
+		if !created {
+			h.logger.Info("Mail skipped, sent recently", "user_id", user.ID, "purpose", purpose)
+			return
+		}
+
+		link := strings.TrimSuffix(h.config.Issuer, "/") + path + "?" + url.Values{"token": {token}}.Encode()
+		msg := compose(link, time.Now().Add(ttl).Format("02.01.2006 15:04 MST"))
+		msg.To = user.Email
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			mailSentTotal.WithLabelValues(purpose, "failed").Inc()
+			h.logger.Error("Failed to send mail", "user_id", user.ID, "purpose", purpose, "error", err)
+			return
+		}
+
+		mailSentTotal.WithLabelValues(purpose, "sent").Inc()
+		h.logger.Info("Mail sent", "user_id", user.ID, "purpose", purpose)
+	}()
+}
+
+// sendEmailVerification отправляет пользователю ссылку подтверждения адреса
+func (h *Handler) sendEmailVerification(user *models.User) {
+	h.sendUserToken(storage.UserTokenEmailVerification, user, h.config.EmailVerificationTTL, PathEmailVerify, func(link, expires string) mailer.Message {
+		return mailer.Message{
+			Subject: "Подтверждение адреса электронной почты",
+			Body: "Здравствуйте, " + user.Username + "!\n\n" +
+				"Чтобы подтвердить адрес электронной почты, перейдите по ссылке:\n" + link + "\n\n" +
+				"Ссылка действует до " + expires + ". Если вы не регистрировались, проигнорируйте это письмо.\n",
+		}
+	})
+}
+
+// sendPasswordReset отправляет пользователю ссылку сброса пароля
+func (h *Handler) sendPasswordReset(user *models.User) {
+	h.sendUserToken(storage.UserTokenPasswordReset, user, h.config.PasswordResetTTL, PathPasswordReset, func(link, expires string) mailer.Message {
+		return mailer.Message{
+			Subject: "Сброс пароля",
+			Body: "Здравствуйте, " + user.Username + "!\n\n" +
+				"Чтобы задать новый пароль, перейдите по ссылке:\n" + link + "\n\n" +
+				"Ссылка действует до " + expires + " и может быть использована один раз. " +
+				"Если вы не запрашивали сброс пароля, проигнорируйте это письмо.\n",
+		}
+	})
+}
+
+// decodeEmailRequest читает адрес из JSON запроса письма
+func (h *Handler) decodeEmailRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req models.EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return "", false
+	}
+
+	email, ok := normalizeEmail(req.Email)
+	if !ok || email == "" {
+		h.writeErrorResponse(w, "invalid_request", "Invalid email address", http.StatusBadRequest)
+		return "", false
+	}
+	return email, true
+}
+
+// ResendEmailVerification godoc
+// @Summary Повторное письмо подтверждения адреса
+// @Description Отправляет ссылку подтверждения на адрес пользователя, если он зарегистрирован и не подтвержден. Ответ не зависит от наличия пользователя, письма одному пользователю отправляются не чаще раза в минуту
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.EmailRequest true "Адрес электронной почты"
+// @Success 202 {object} map[string]string "Запрос принят"
+// @Failure 400 {object} map[string]string "Неверный адрес"
+// @Router /email/verification [post]
+func (h *Handler) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	email, ok := h.decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		h.logger.Error("Failed to get user by email", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if user != nil && !user.EmailVerified {
+		h.sendEmailVerification(user)
+	}
+
+	h.writeJSONResponse(w, map[string]string{"status": "accepted"}, http.StatusAccepted)
+}
+
+// VerifyEmail godoc
+// @Summary Подтверждение адреса электронной почты
+// @Description Ссылка из письма. Токен одноразовый; адрес подтверждается, только если он не изменился после отправки письма
+// @Tags users
+// @Produce html
+// @Param token query string true "Токен из письма"
+// @Success 200 {string} string "Адрес подтвержден"
+// @Failure 400 {string} string "Ссылка недействительна или устарела"
+// @Router /email/verify [get]
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := messagePage{Title: "Подтверждение адреса"}
+
+	userToken, err := h.store.UseUserToken(ctx, storage.UserTokenEmailVerification, r.URL.Query().Get("token"))
+	if err != nil {
+		h.logger.Error("Failed to use email verification token", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	verified := false
+	if userToken != nil {
+		verified, err = h.store.MarkEmailVerified(ctx, userToken.UserID, userToken.Email)
+		if err != nil {
+			h.logger.Error("Failed to verify email", "user_id", userToken.UserID, "error", err)
+			h.writeErrorResponse(w, "server_error", "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !verified {
+		page.Error = "Ссылка недействительна или устарела. Запросите письмо повторно"
+		h.renderTemplate(w, "message.html", page, http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Info("Email verified", "user_id", userToken.UserID)
+	page.Notice = "Адрес электронной почты подтвержден"
+	h.renderTemplate(w, "message.html", page, http.StatusOK)
+}
+
+// ForgotPassword godoc
+// @Summary Запрос сброса пароля
+// @Description Отправляет ссылку сброса пароля на адрес пользователя. Ответ не зависит от наличия пользователя, письма одному пользователю отправляются не чаще раза в минуту
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.EmailRequest true "Адрес электронной почты"
+// @Success 202 {object} map[string]string "Запрос принят"
+// @Failure 400 {object} map[string]string "Неверный адрес"
+// @Router /password/forgot [post]
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	email, ok := h.decodeEmailRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := h.store.GetUserByEmail(ctx, email)
+	if err != nil {
+		h.logger.Error("Failed to get user by email", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to send password reset email", http.StatusInternalServerError)
+		return
+	}
+	if user != nil {
+		h.sendPasswordReset(user)
+	}
+
+	h.writeJSONResponse(w, map[string]string{"status": "accepted"}, http.StatusAccepted)
+}
+
+// PasswordResetForm godoc
+// @Summary Форма нового пароля
+// @Description Ссылка из письма сброса пароля. Токен проверяется при отправке формы
+// @Tags users
+// @Produce html
+// @Param token query string true "Токен из письма"
+// @Success 200 {string} string "HTML-форма"
+// @Router /password/reset [get]
+func (h *Handler) PasswordResetForm(w http.ResponseWriter, r *http.Request) {
+	h.renderPasswordReset(w, r, passwordResetPage{Token: r.URL.Query().Get("token")}, http.StatusOK)
+}
+
+// ResetPassword godoc
+// @Summary Установка нового пароля
+// @Description Устанавливает пароль по одноразовому токену из письма: форма страницы /password/reset с CSRF токеном или JSON запрос.
+// @Description Сессии пользователя завершаются, выданные токены отзываются, блокировка входа по имени пользователя снимается
+// @Tags users
+// @Accept json
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Produce html
+// @Param request body models.PasswordResetRequest true "Токен и новый пароль"
+// @Success 200 {object} map[string]string "Пароль изменен"
+// @Failure 400 {object} map[string]string "Ссылка недействительна или пароль не задан"
+// @Router /password/reset [post]
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		h.resetPasswordJSON(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	page := passwordResetPage{Token: r.PostFormValue("token")}
+	if !h.validCSRFToken(r) {
+		h.logger.Warn("Password reset CSRF token mismatch", "ip", clientIP(r))
+		page.Error = "Срок действия формы истек, попробуйте еще раз"
+		h.renderPasswordReset(w, r, page, http.StatusForbidden)
+		return
+	}
+
+	password := r.PostFormValue("password")
+	if password == "" || password != r.PostFormValue("password_confirm") {
+		page.Error = "Пароли не совпадают"
+		h.renderPasswordReset(w, r, page, http.StatusBadRequest)
+		return
+	}
+
+	reset, err := h.resetPassword(r.Context(), page.Token, password)
+	if err != nil {
+		h.writeErrorResponse(w, "server_error", "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if !reset {
+		h.renderTemplate(w, "message.html", messagePage{
+			Title: "Сброс пароля",
+			Error: "Ссылка недействительна или устарела. Запросите сброс пароля повторно",
+		}, http.StatusBadRequest)
+		return
+	}
+
+	h.renderTemplate(w, "message.html", messagePage{
+		Title:  "Сброс пароля",
+		Notice: "Пароль изменен. Войдите с новым паролем",
+	}, http.StatusOK)
+}
+
+// resetPasswordJSON устанавливает пароль по JSON запросу
+func (h *Handler) resetPasswordJSON(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		h.writeErrorResponse(w, "invalid_request", "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	reset, err := h.resetPassword(r.Context(), req.Token, req.Password)
+	if err != nil {
+		h.writeErrorResponse(w, "server_error", "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	if !reset {
+		h.writeErrorResponse(w, "invalid_token", "Password reset token is invalid or expired", http.StatusBadRequest)
+		return
+	}
+
+	h.writeJSONResponse(w, map[string]string{"status": "password_reset"}, http.StatusOK)
+}
+
+// resetPassword использует токен сброса и меняет пароль. Возвращает false для недействительного
+// токена или токена, отправленного на прежний адрес пользователя. Переход по ссылке из письма
+// подтверждает владение адресом
+func (h *Handler) resetPassword(ctx context.Context, token, password string) (bool, error) {
+	userToken, err := h.store.UseUserToken(ctx, storage.UserTokenPasswordReset, token)
+	if err != nil {
+		h.logger.Error("Failed to use password reset token", "error", err)
+		return false, err
+	}
+	if userToken == nil {
+		return false, nil
+	}
+
+	user, err := h.store.GetUserByID(ctx, userToken.UserID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "user_id", userToken.UserID, "error", err)
+		return false, err
+	}
+	if !strings.EqualFold(user.Email, userToken.Email) {
+		h.logger.Warn("Password reset token for previous email", "user_id", user.ID)
+		return false, nil
+	}
+
+	if err := h.store.ResetPassword(ctx, user.ID, password); err != nil {
+		h.logger.Error("Failed to reset password", "user_id", user.ID, "error", err)
+		return false, err
+	}
+	if _, err := h.store.MarkEmailVerified(ctx, user.ID, userToken.Email); err != nil {
+		h.logger.Error("Failed to verify email", "user_id", user.ID, "error", err)
+	}
+	h.loginSucceeded(ctx, user)
+
+	h.logger.Info("Password reset", "user_id", user.ID)
+	return true, nil
+}
+
+// renderPasswordReset отображает форму нового пароля с новым или текущим CSRF токеном
+func (h *Handler) renderPasswordReset(w http.ResponseWriter, r *http.Request, page passwordResetPage, statusCode int) {
+	token, err := h.csrfToken(w, r)
+	if err != nil {
+		h.logger.Error("Failed to generate CSRF token", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render password reset page", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Новый пароль"
+	page.CSRFToken = token
+	h.renderTemplate(w, "password_reset.html", page, statusCode)
+}
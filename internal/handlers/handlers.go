@@ -3,77 +3,161 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"go_oauth2_server/internal/config"
 	"go_oauth2_server/internal/jwt"
+	"go_oauth2_server/internal/mailer"
+	"go_oauth2_server/internal/mfa"
 	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/ratelimit"
 	"go_oauth2_server/internal/storage"
 
 	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/manage"
 	"github.com/go-oauth2/oauth2/v4/server"
+	"github.com/go-webauthn/webauthn/webauthn"
 	jwtLib "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type Handler struct {
-	store  *storage.PostgresStore
-	logger *slog.Logger
-	srv    *server.Server
-	config *config.Config
+	store   *storage.PostgresStore
+	keys    jwt.KeyProvider
+	logger  *slog.Logger
+	srv     *server.Server
+	config  *config.Config
+	limiter ratelimit.Limiter
+	// mfaCipher шифрует секреты TOTP пользователей
+	mfaCipher *mfa.Cipher
+	// webauthn проверяет регистрацию и использование ключей доступа
+	webauthn *webauthn.WebAuthn
+	// mailer отправляет письма подтверждения адреса и сброса пароля
+	mailer mailer.Mailer
 }
 
-func New(store *storage.PostgresStore, logger *slog.Logger, cfg *config.Config) *Handler {
+func New(store *storage.PostgresStore, keys jwt.KeyProvider, logger *slog.Logger, cfg *config.Config, limiter ratelimit.Limiter, passkeys *webauthn.WebAuthn, mail mailer.Mailer) *Handler {
 	manager := manage.NewDefaultManager()
 
-	// Конфигурация токенов
-	manager.SetAuthorizeCodeTokenCfg(manage.DefaultAuthorizeCodeTokenCfg)
-	manager.SetRefreshTokenCfg(manage.DefaultRefreshTokenCfg)
+	// Конфигурация токенов. Время жизни access токена берется из конфигурации: от него зависит,
+	// сколько выведенный из подписи ключ остается в JWKS
+	tokenCfg := &manage.Config{
+		AccessTokenExp:    cfg.TokenExpiration,
+		RefreshTokenExp:   cfg.RefreshExpiration,
+		IsGenerateRefresh: true,
+	}
+	manager.SetAuthorizeCodeTokenCfg(tokenCfg)
+	manager.SetPasswordTokenCfg(tokenCfg)
+	manager.SetImplicitTokenCfg(&manage.Config{AccessTokenExp: cfg.TokenExpiration})
+	manager.SetClientTokenCfg(&manage.Config{AccessTokenExp: cfg.TokenExpiration})
+	// Ротация refresh токенов: каждое обновление выдает новый refresh токен того же семейства,
+	// а старый помечается использованным. Срок жизни семейства при ротации не продлевается.
+	// IsRemoveAccess не находит прежний access токен: хранилище не знает его значения
+	// по refresh токену, поэтому его отзывает RemoveByRefresh
+	manager.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		IsGenerateRefresh:  true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
+	})
 
 	// Генерация JWT access токенов
-	jwtGen := jwt.NewJWTAccessGenerate([]byte(cfg.JWTSecret), jwtLib.SigningMethodHS256)
+	jwtGen := jwt.NewJWTAccessGenerate(keys)
+	jwtGen.Issuer = cfg.Issuer
 	manager.MapAccessGenerate(jwtGen)
 
+	// nonce и время аутентификации переносятся в код и токены для ID токена
+	manager.SetExtractExtensionHandler(extractAuthentication)
+
 	// Хранилище клиентов
 	manager.MapClientStorage(store.GetClientStore())
 
+	// Библиотека сверяет redirect URI с доменом клиента по суффиксу хоста. Вместо этого /authorize
+	// требует точного совпадения с зарегистрированным адресом до передачи запроса библиотеке,
+	// а при обмене кода библиотека сравнивает redirect_uri с сохраненным в коде
+	manager.SetValidateURIHandler(func(baseURI, redirectURI string) error { return nil })
+
 	// Хранилище токенов
 	manager.MapTokenStorage(store.GetTokenStore())
 
 	srv := server.NewDefaultServer(manager)
 	srv.SetAllowGetAccessRequest(true)
-	srv.SetClientInfoHandler(server.ClientFormHandler)
-
-	// Обработка авторизации по логину и паролю
-	srv.SetPasswordAuthorizationHandler(func(ctx context.Context, clientID, username, password string) (userID string, err error) {
-		user, err := store.ValidateUser(ctx, username, password)
-		if err != nil {
-			return "", err
+	srv.SetClientInfoHandler(clientInfoHandler)
+
+	// Библиотека возвращает ErrMissingCodeVerifier без описания ответа, что приводит к server_error.
+	// По RFC 7636 §4.6 отсутствие или несовпадение code_verifier — это invalid_grant.
+	srv.SetInternalErrorHandler(func(err error) *oauthErrors.Response {
+		if errors.Is(err, oauthErrors.ErrMissingCodeVerifier) {
+			re := oauthErrors.NewResponse(oauthErrors.ErrInvalidGrant, http.StatusBadRequest)
+			re.Description = oauthErrors.Descriptions[oauthErrors.ErrInvalidGrant]
+			return re
+		}
+		// prompt=none без сессии или без согласия (OpenID Connect Core §3.1.2.6)
+		if errors.Is(err, errLoginRequired) {
+			re := oauthErrors.NewResponse(errLoginRequired, http.StatusBadRequest)
+			re.Description = "User authentication is required"
+			return re
+		}
+		if errors.Is(err, errConsentRequired) {
+			re := oauthErrors.NewResponse(errConsentRequired, http.StatusBadRequest)
+			re.Description = "User consent is required"
+			return re
+		}
+		// Password grant для заблокированного имени пользователя или IP адреса
+		if errors.Is(err, errLoginLocked) {
+			re := oauthErrors.NewResponse(oauthErrors.ErrInvalidGrant, http.StatusBadRequest)
+			re.Description = "Too many failed login attempts, try again later"
+			if retryAfter, ok := loginRetryAfter(err); ok {
+				re.SetHeader("Retry-After", retryAfter)
+			}
+			return re
+		}
+		// Password grant пользователя с неподтвержденным адресом при REQUIRE_VERIFIED_EMAIL
+		if errors.Is(err, errEmailNotVerified) {
+			re := oauthErrors.NewResponse(oauthErrors.ErrInvalidGrant, http.StatusBadRequest)
+			re.Description = "Email address is not verified"
+			return re
 		}
-		return user.ID, nil
+		// Password grant пользователя со вторым фактором без параметра otp
+		if errors.Is(err, errOTPRequired) {
+			re := oauthErrors.NewResponse(oauthErrors.ErrInvalidGrant, http.StatusBadRequest)
+			re.Description = "One-time password is required"
+			return re
+		}
+		return nil
 	})
 
-	// Обработка пользовательской авторизации
-	srv.SetUserAuthorizationHandler(func(w http.ResponseWriter, r *http.Request) (userID string, err error) {
-		return r.FormValue("user_id"), nil
-	})
+	h := &Handler{
+		store:     store,
+		keys:      keys,
+		logger:    logger,
+		srv:       srv,
+		config:    cfg,
+		limiter:   limiter,
+		mfaCipher: mfa.NewCipher(cfg.MFAEncryptionKey),
+		webauthn:  passkeys,
+		mailer:    mail,
+	}
 
-	// Обработка авторизации клиента
-	srv.SetClientAuthorizedHandler(func(clientID string, grant oauth2.GrantType) (allowed bool, err error) {
-		// Разрешаем все grant типы для простоты — в проде стоит сделать полноценную проверку
-		return true, nil
-	})
+	// Клиент использует только зарегистрированные grant types и response types, иначе получает
+	// unauthorized_client. Scope сужаются до разрешенных клиенту, при обновлении — до выданных ранее
+	srv.SetClientAuthorizedHandler(h.clientAuthorized)
+	srv.SetClientScopeHandler(h.clientScopeAllowed)
+	srv.SetRefreshingScopeHandler(refreshScopeAllowed)
 
-	return &Handler{
-		store:  store,
-		logger: logger,
-		srv:    srv,
-		config: cfg,
-	}
+	// Password grant проверяет пароль с защитой от перебора, как страница входа
+	srv.SetPasswordAuthorizationHandler(h.passwordAuthorization)
+
+	// Пользователь определяется по сессии или по учетным данным JSON запроса, но не по параметрам формы
+	srv.SetUserAuthorizationHandler(h.authorizeUser)
+
+	return h
 }
 
 // AuthorizeGet godoc
@@ -117,7 +201,9 @@ func New(store *storage.PostgresStore, logger *slog.Logger, cfg *config.Config)
 func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	if r.Method == "POST" {
+	var auth authentication
+	// Решение на экране согласия отправляется формой, остальные POST запросы передают JSON
+	if r.Method == "POST" && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
 		var req models.AuthorizeRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			h.logger.Error("Failed to decode authorize request", "error", err)
@@ -133,26 +219,74 @@ func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
 
 		// Проверка логина и пароля, если они переданы
 		if req.Username != "" && req.Password != "" {
-			user, err := h.store.ValidateUser(ctx, req.Username, req.Password)
+			user, err := h.authenticateUser(ctx, req.Username, req.Password, clientIP(r))
+			if errors.Is(err, errLoginLocked) {
+				setLoginRetryAfter(w, err)
+				h.writeErrorResponse(w, "access_denied", "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+				return
+			}
+			if errors.Is(err, errEmailNotVerified) {
+				h.writeErrorResponse(w, "access_denied", "Email address is not verified", http.StatusForbidden)
+				return
+			}
 			if err != nil {
 				h.logger.Error("Invalid user credentials", "username", req.Username, "error", err)
 				h.writeErrorResponse(w, "access_denied", "Invalid credentials", http.StatusUnauthorized)
 				return
 			}
-
-			// Установка параметров формы для сервера OAuth2
-			if r.Form == nil {
-				r.Form = url.Values{}
+			amr, err := h.secondFactor(ctx, user, req.OTP, clientIP(r))
+			switch {
+			case errors.Is(err, errLoginLocked):
+				setLoginRetryAfter(w, err)
+				h.writeErrorResponse(w, "access_denied", "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+				return
+			case errors.Is(err, errOTPRequired):
+				h.writeErrorResponse(w, "access_denied", "One-time password is required", http.StatusUnauthorized)
+				return
+			case errors.Is(err, errInvalidOTP):
+				h.logger.Warn("Invalid second factor", "user_id", user.ID)
+				h.writeErrorResponse(w, "access_denied", "Invalid one-time password", http.StatusUnauthorized)
+				return
+			case err != nil:
+				h.logger.Error("Failed to verify second factor", "user_id", user.ID, "error", err)
+				h.writeErrorResponse(w, "server_error", "Authorization failed", http.StatusInternalServerError)
+				return
 			}
-			r.Form.Set("response_type", req.ResponseType)
-			r.Form.Set("client_id", req.ClientID)
-			r.Form.Set("redirect_uri", req.RedirectURI)
-			r.Form.Set("scope", req.Scope)
-			r.Form.Set("state", req.State)
-			r.Form.Set("user_id", user.ID)
+			auth.userID, auth.authTime, auth.amr = user.ID, time.Now(), amr
 		}
+
+		// Установка параметров формы для сервера OAuth2
+		if r.Form == nil {
+			r.Form = url.Values{}
+		}
+		r.Form.Set("response_type", req.ResponseType)
+		r.Form.Set("client_id", req.ClientID)
+		r.Form.Set("redirect_uri", req.RedirectURI)
+		r.Form.Set("scope", req.Scope)
+		r.Form.Set("state", req.State)
+		r.Form.Set("code_challenge", req.CodeChallenge)
+		r.Form.Set("code_challenge_method", req.CodeChallengeMethod)
+		r.Form.Set("nonce", req.Nonce)
 	}
 
+	if !h.validateAuthorizeRedirect(w, r) {
+		return
+	}
+
+	if err := h.validateAuthorizePKCE(r); err != nil {
+		h.writeErrorResponse(w, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Без учетных данных в запросе пользователь определяется по сессии
+	if auth.userID == "" {
+		if session := h.currentSession(r); session != nil {
+			auth.userID, auth.authTime, auth.amr = session.UserID, session.AuthTime, session.AMR
+		}
+	}
+	auth.nonce = r.FormValue("nonce")
+	r = withAuthentication(r, auth)
+
 	if err := h.srv.HandleAuthorizeRequest(w, r); err != nil {
 		h.logger.Error("Authorization request failed", "error", err)
 		h.writeErrorResponse(w, "server_error", "Authorization failed", http.StatusInternalServerError)
@@ -185,19 +319,105 @@ func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
 // }"
 // @Router /token [post]
 func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
-	if err := h.srv.HandleTokenRequest(w, r); err != nil {
+	if err := h.validateTokenPKCE(r); err != nil {
+		h.writeErrorResponse(w, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.authorizeRefresh(w, r) {
+		return
+	}
+
+	// В password grant пользователь аутентифицируется в момент выдачи токена
+	if r.FormValue("grant_type") == string(oauth2.PasswordCredentials) {
+		r = withPasswordGrant(withAuthentication(r, authentication{authTime: time.Now()}))
+	}
+
+	// Повторяет HandleTokenRequest библиотеки: ответ отправляется только после выпуска ID токена,
+	// чтобы ошибка выпуска не превратилась в успешный ответ без id_token
+	ctx := r.Context()
+	gt, tgr, err := h.srv.ValidationTokenRequest(r)
+	if err != nil {
+		h.writeTokenError(w, err)
+		return
+	}
+	ti, err := h.srv.GetAccessToken(ctx, gt, tgr)
+	if err != nil {
+		h.writeTokenError(w, err)
+		return
+	}
+
+	data := h.srv.GetTokenData(ti)
+	// ID токен OpenID Connect при запросе scope openid
+	if idTokenRequired(ti) {
+		idToken, err := h.issueIDToken(ctx, ti)
+		if err != nil {
+			h.logger.Error("Failed to issue ID token", "client_id", ti.GetClientID(), "error", err)
+			h.revokeIssuedToken(ctx, ti)
+			h.writeTokenError(w, oauthErrors.ErrServerError)
+			return
+		}
+		data["id_token"] = idToken
+	}
+	h.writeTokenResponse(w, data, nil, http.StatusOK)
+}
+
+// writeTokenError отправляет ошибку токен-эндпоинта в формате библиотеки OAuth2 (RFC 6749 §5.2)
+func (h *Handler) writeTokenError(w http.ResponseWriter, err error) {
+	data, statusCode, header := h.srv.GetErrorData(err)
+	h.writeTokenResponse(w, data, header, statusCode)
+}
+
+// writeTokenResponse отправляет ответ токен-эндпоинта с запретом кеширования (RFC 6749 §5.1)
+func (h *Handler) writeTokenResponse(w http.ResponseWriter, data map[string]interface{}, header http.Header, statusCode int) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	for key := range header {
+		w.Header().Set(key, header.Get(key))
+	}
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
 		h.logger.Error("Token request failed", "error", err)
-		// Сервер OAuth2 сам отправит корректный ответ об ошибке
 	}
 }
 
+// Introspect godoc
+// @Summary Интроспекция токена
+// @Description Проверка активности токена (RFC 7662). Вызывающий ресурсный сервер аутентифицируется как конфиденциальный клиент или Bearer токеном client_credentials со scope introspect
+// @Tags token
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Проверяемый токен"
+// @Param token_type_hint formData string false "access_token или refresh_token"
+// @Success 200 {object} models.IntrospectResponse
+// @Failure 400 {object} map[string]string "Неверный запрос"
+// @Failure 401 {object} map[string]string "Ошибка аутентификации вызывающей стороны"
+// @Router /introspect [post]
 func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var req models.IntrospectRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Error("Failed to decode introspect request", "error", err)
-		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		// JSON оставлен для обратной совместимости, стандартный формат — form-urlencoded
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.logger.Error("Failed to decode introspect request", "error", err)
+			h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			h.logger.Error("Failed to parse introspect request", "error", err)
+			h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		req.Token = r.PostFormValue("token")
+		req.TokenTypeHint = r.PostFormValue("token_type_hint")
+	}
+
+	if !h.authenticateIntrospectionCaller(r) {
+		h.writeInvalidClient(w)
 		return
 	}
 
@@ -206,40 +426,123 @@ func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var response models.IntrospectResponse
+	if req.TokenTypeHint == tokenTypeHintRefresh {
+		response = h.introspectRefreshToken(ctx, req.Token)
+		if !response.Active {
+			response = h.introspectAccessToken(ctx, req.Token)
+		}
+	} else {
+		response = h.introspectAccessToken(ctx, req.Token)
+		if !response.Active {
+			response = h.introspectRefreshToken(ctx, req.Token)
+		}
+	}
+
+	if response.Active && response.Sub != "" {
+		if user, err := h.store.GetUserByID(ctx, response.Sub); err == nil {
+			response.Username = user.Username
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, response, http.StatusOK)
+}
+
+// scopeIntrospect ограниченный scope, с которым ресурсный сервер вызывает интроспекцию по Bearer токену
+const scopeIntrospect = "introspect"
+
+// authenticateIntrospectionCaller аутентифицирует ресурсный сервер (RFC 7662 §2.1): конфиденциального
+// клиента по Basic/form или по Bearer токену client_credentials со scope introspect. Токен пользователя
+// не подходит, иначе любой пользователь мог бы проверять чужие токены
+func (h *Handler) authenticateIntrospectionCaller(r *http.Request) bool {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		info := h.introspectAccessToken(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+		if !info.Active || info.Sub != "" || !hasScope(info.Scope, scopeIntrospect) {
+			h.logger.Warn("Introspection bearer caller rejected", "active", info.Active, "client_id", info.ClientID, "sub", info.Sub)
+			return false
+		}
+		return true
+	}
+
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.logger.Warn("Introspection caller authentication failed", "error", err)
+		return false
+	}
+	return !client.Public
+}
+
+// introspectAccessToken проверяет access токен
+func (h *Handler) introspectAccessToken(ctx context.Context, token string) models.IntrospectResponse {
 	// Для JWT токенов можем валидировать их напрямую
-	if h.isJWTToken(req.Token) {
-		response := h.validateJWTToken(req.Token)
-		h.writeJSONResponse(w, response, http.StatusOK)
-		return
+	if h.isJWTToken(token) {
+		if response := h.validateJWTToken(ctx, token); response.Active {
+			return response
+		}
 	}
 
 	// В противном случае к стандартной валидации через OAuth2 manager
-	ti, err := h.srv.Manager.LoadAccessToken(ctx, req.Token)
+	ti, err := h.srv.Manager.LoadAccessToken(ctx, token)
 	if err != nil {
 		// Токен недействителен или просрочен
-		response := models.IntrospectResponse{Active: false}
-		h.writeJSONResponse(w, response, http.StatusOK)
-		return
+		return models.IntrospectResponse{Active: false}
 	}
 
 	// Проверка срока действия токена
 	expiresAt := ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn())
 	if expiresAt.Before(time.Now()) {
-		response := models.IntrospectResponse{Active: false}
-		h.writeJSONResponse(w, response, http.StatusOK)
-		return
+		return models.IntrospectResponse{Active: false}
+	}
+
+	var amr []string
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok {
+		amr = strings.Fields(eti.GetExtension().Get(jwt.ExtensionAMR))
 	}
 
 	// Токен действителен
+	return models.IntrospectResponse{
+		Active:    true,
+		Scope:     ti.GetScope(),
+		ClientID:  ti.GetClientID(),
+		TokenType: h.srv.Config.TokenType,
+		Exp:       expiresAt.Unix(),
+		Iat:       ti.GetAccessCreateAt().Unix(),
+		Nbf:       ti.GetAccessCreateAt().Unix(),
+		Sub:       ti.GetUserID(),
+		Aud:       ti.GetClientID(),
+		Iss:       h.config.Issuer,
+		AMR:       amr,
+		UserID:    ti.GetUserID(),
+	}
+}
+
+// introspectRefreshToken проверяет refresh токен
+func (h *Handler) introspectRefreshToken(ctx context.Context, token string) models.IntrospectResponse {
+	ti, err := h.srv.Manager.LoadRefreshToken(ctx, token)
+	if err != nil {
+		return models.IntrospectResponse{Active: false}
+	}
+
 	response := models.IntrospectResponse{
-		Active:   true,
-		ClientID: ti.GetClientID(),
-		UserID:   ti.GetUserID(),
-		Scope:    ti.GetScope(),
-		Exp:      expiresAt.Unix(),
+		Active:    true,
+		Scope:     ti.GetScope(),
+		ClientID:  ti.GetClientID(),
+		TokenType: tokenTypeHintRefresh,
+		Iat:       ti.GetRefreshCreateAt().Unix(),
+		Nbf:       ti.GetRefreshCreateAt().Unix(),
+		Sub:       ti.GetUserID(),
+		Aud:       ti.GetClientID(),
+		Iss:       h.config.Issuer,
+		UserID:    ti.GetUserID(),
 	}
 
-	h.writeJSONResponse(w, response, http.StatusOK)
+	// Нулевой срок означает бессрочный refresh токен
+	if exp := ti.GetRefreshExpiresIn(); exp > 0 {
+		response.Exp = ti.GetRefreshCreateAt().Add(exp).Unix()
+	}
+
+	return response
 }
 
 // isJWTToken предварительная валидация JWT,  проверяет, является ли строка JWT-токеном
@@ -249,22 +552,25 @@ func (h *Handler) isJWTToken(tokenString string) bool {
 	return parts && (tokenString[0] == 'e' || tokenString[0] == 'E') // JWT обычно начинается с eyJ
 }
 
-// validateJWTToken прямая валидация JWT-токена
-func (h *Handler) validateJWTToken(tokenString string) models.IntrospectResponse {
-	token, err := jwtLib.Parse(tokenString, func(token *jwtLib.Token) (interface{}, error) {
-		// Проверка метода подписи
-		if _, ok := token.Method.(*jwtLib.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrInvalidSigningMethod
-		}
-		return []byte(h.config.JWTSecret), nil
-	})
-
+// parseJWTClaims проверяет подпись и срок действия JWT и возвращает его claims
+func (h *Handler) parseJWTClaims(tokenString string) (jwtLib.MapClaims, error) {
+	// Ключ выбирается по kid, метод подписи должен совпадать с алгоритмом ключа
+	token, err := jwtLib.Parse(tokenString, jwt.Keyfunc(h.keys))
 	if err != nil || !token.Valid {
-		return models.IntrospectResponse{Active: false}
+		return nil, jwt.ErrInvalidToken
 	}
 
 	claims, ok := token.Claims.(jwtLib.MapClaims)
 	if !ok {
+		return nil, jwt.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// validateJWTToken прямая валидация JWT-токена
+func (h *Handler) validateJWTToken(ctx context.Context, tokenString string) models.IntrospectResponse {
+	claims, err := h.parseJWTClaims(tokenString)
+	if err != nil {
 		return models.IntrospectResponse{Active: false}
 	}
 
@@ -275,20 +581,58 @@ func (h *Handler) validateJWTToken(tokenString string) models.IntrospectResponse
 		}
 	}
 
+	// Проверка denylist отозванных токенов
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		revoked, err := h.store.IsJTIRevoked(ctx, jti)
+		if err != nil {
+			h.logger.Error("Failed to check token revocation", "error", err)
+			return models.IntrospectResponse{Active: false}
+		}
+		if revoked {
+			return models.IntrospectResponse{Active: false}
+		}
+	}
+
 	// Извлечение данных из claims
 	clientID, _ := claims["aud"].(string)
-	username, _ := claims["sub"].(string)
+	subject, _ := claims["sub"].(string)
+	issuer, _ := claims["iss"].(string)
+	scope, _ := claims["scope"].(string)
+	jti, _ := claims["jti"].(string)
+	amr := claimStrings(claims["amr"])
 	exp, _ := claims["exp"].(float64)
+	iat, _ := claims["iat"].(float64)
+	nbf, _ := claims["nbf"].(float64)
 
 	return models.IntrospectResponse{
-		Active:   true,
-		ClientID: clientID,
-		UserID:   username,
-		Scope:    "", // Сейчас не используем
-		Exp:      int64(exp),
+		Active:    true,
+		Scope:     scope,
+		ClientID:  clientID,
+		TokenType: h.srv.Config.TokenType,
+		Exp:       int64(exp),
+		Iat:       int64(iat),
+		Nbf:       int64(nbf),
+		Sub:       subject,
+		Aud:       clientID,
+		Iss:       issuer,
+		Jti:       jti,
+		AMR:       amr,
+		UserID:    subject,
 	}
 }
 
+// claimStrings значение claim со списком строк
+func claimStrings(claim interface{}) []string {
+	values, _ := claim.([]interface{})
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -299,6 +643,14 @@ func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 		Password    string   `json:"password"`
 		RedirectURI []string `json:"redirect_uris,omitempty"`
 		GrantTypes  []string `json:"grant_types,omitempty"`
+		// ResponseTypes по умолчанию выводятся из GrantTypes
+		ResponseTypes []string `json:"response_types,omitempty"`
+		// Scope разрешенные клиенту scope через пробел, без него доступны все, кроме restricted
+		Scope       string `json:"scope,omitempty"`
+		Public      bool   `json:"is_public,omitempty"`
+		RequirePKCE bool   `json:"require_pkce,omitempty"`
+		// UserinfoSignedResponseAlg запрашивает ответ /userinfo в виде подписанного JWT
+		UserinfoSignedResponseAlg string `json:"userinfo_signed_response_alg,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -307,6 +659,43 @@ func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Без redirect_uris единственным адресом становится домен, если он задан как URL
+	if len(req.RedirectURI) == 0 && validateRedirectURI(req.Domain) == nil {
+		req.RedirectURI = []string{req.Domain}
+	}
+	for _, redirectURI := range req.RedirectURI {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			h.writeErrorResponse(w, "invalid_redirect_uri", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	grantTypes, responseTypes, err := h.clientGrants(req.Public, req.GrantTypes, req.ResponseTypes)
+	if err != nil {
+		h.writeErrorResponse(w, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var allowedScopes []string
+	if req.Scope != "" {
+		registered, err := h.store.ListScopes(ctx)
+		if err != nil {
+			h.logger.Error("Failed to list scopes", "error", err)
+			h.writeErrorResponse(w, "server_error", "Failed to create client", http.StatusInternalServerError)
+			return
+		}
+		if allowedScopes, err = registrationScopes(registered, req.Scope); err != nil {
+			h.writeErrorResponse(w, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Подписать ответ можно только асимметричным ключом, которым сервер подписывает токены
+	if alg := req.UserinfoSignedResponseAlg; alg != "" && !slices.Contains(h.userInfoSigningAlgorithms(), alg) {
+		h.writeErrorResponse(w, "invalid_client_metadata", "Unsupported userinfo_signed_response_alg", http.StatusBadRequest)
+		return
+	}
+
 	// Создаем пользователя, если указаны username и password
 	if req.Username != "" && req.Password != "" {
 		user := &models.User{
@@ -325,13 +714,33 @@ func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 		req.UserID = user.ID
 	}
 
-	// Создаем клиента
+	// Секрет возвращается только в ответе на регистрацию, в БД сохраняется его хеш
+	secret, err := randomToken(32)
+	if err != nil {
+		h.logger.Error("Failed to generate client secret", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to create client", http.StatusInternalServerError)
+		return
+	}
+
+	// Создаем клиента. Публичные клиенты не получают секрет и всегда используют PKCE
 	client := &models.Client{
-		ID:        uuid.New().String(),
-		Secret:    uuid.New().String(),
-		Domain:    req.Domain,
-		UserID:    req.UserID,
-		CreatedAt: time.Now(),
+		ID:          uuid.New().String(),
+		Secret:      secret,
+		Domain:      req.Domain,
+		UserID:      req.UserID,
+		Public:      req.Public,
+		RequirePKCE: req.RequirePKCE || req.Public,
+		CreatedAt:   time.Now(),
+
+		RedirectURIs:  req.RedirectURI,
+		GrantTypes:    grantTypes,
+		ResponseTypes: responseTypes,
+		AllowedScopes: allowedScopes,
+
+		UserinfoSignedResponseAlg: req.UserinfoSignedResponseAlg,
+	}
+	if client.Public {
+		client.Secret = ""
 	}
 
 	if err := h.store.CreateClient(ctx, client); err != nil {
@@ -341,11 +750,24 @@ func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := map[string]interface{}{
-		"client_id":     client.ID,
-		"client_secret": client.Secret,
-		"domain":        client.Domain,
-		"user_id":       client.UserID,
-		"created_at":    client.CreatedAt.Unix(),
+		"client_id":      client.ID,
+		"domain":         client.Domain,
+		"user_id":        client.UserID,
+		"is_public":      client.Public,
+		"require_pkce":   client.RequirePKCE,
+		"redirect_uris":  client.RedirectURIs,
+		"grant_types":    client.GrantTypes,
+		"response_types": client.ResponseTypes,
+		"created_at":     client.CreatedAt.Unix(),
+	}
+	if len(client.AllowedScopes) > 0 {
+		response["scope"] = strings.Join(client.AllowedScopes, " ")
+	}
+	if client.UserinfoSignedResponseAlg != "" {
+		response["userinfo_signed_response_alg"] = client.UserinfoSignedResponseAlg
+	}
+	if !client.Public {
+		response["client_secret"] = client.Secret
 	}
 
 	h.logger.Info("Client registered successfully", "client_id", client.ID, "domain", client.Domain)
@@ -356,9 +778,18 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Email    string `json:"email,omitempty"`
+		Username    string          `json:"username"`
+		Password    string          `json:"password"`
+		Email       string          `json:"email,omitempty"`
+		Name        string          `json:"name,omitempty"`
+		GivenName   string          `json:"given_name,omitempty"`
+		FamilyName  string          `json:"family_name,omitempty"`
+		Nickname    string          `json:"nickname,omitempty"`
+		Picture     string          `json:"picture,omitempty"`
+		Locale      string          `json:"locale,omitempty"`
+		Zoneinfo    string          `json:"zoneinfo,omitempty"`
+		PhoneNumber string          `json:"phone_number,omitempty"`
+		Address     *models.Address `json:"address,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -372,14 +803,34 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		h.writeErrorResponse(w, "invalid_request", "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
 	user := &models.User{
-		ID:        uuid.New().String(),
-		Username:  req.Username,
-		Password:  req.Password,
-		CreatedAt: time.Now(),
+		ID:          uuid.New().String(),
+		Username:    req.Username,
+		Password:    req.Password,
+		CreatedAt:   time.Now(),
+		Name:        req.Name,
+		GivenName:   req.GivenName,
+		FamilyName:  req.FamilyName,
+		Nickname:    req.Nickname,
+		Picture:     req.Picture,
+		Locale:      req.Locale,
+		Zoneinfo:    req.Zoneinfo,
+		Email:       email,
+		PhoneNumber: req.PhoneNumber,
+		Address:     req.Address,
 	}
 
 	if err := h.store.CreateUser(ctx, user); err != nil {
+		if errors.Is(err, storage.ErrEmailTaken) {
+			h.writeErrorResponse(w, "invalid_request", "Email address is already registered", http.StatusConflict)
+			return
+		}
 		h.logger.Error("Failed to create user", "error", err)
 		h.writeErrorResponse(w, "server_error", "Failed to create user", http.StatusInternalServerError)
 		return
@@ -390,6 +841,11 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		"username":   user.Username,
 		"created_at": user.CreatedAt.Unix(),
 	}
+	if user.Email != "" {
+		response["email"] = user.Email
+		response["email_verified"] = false
+		h.sendEmailVerification(user)
+	}
 
 	h.logger.Info("User registered successfully", "user_id", user.ID, "username", user.Username)
 	h.writeJSONResponse(w, response, http.StatusCreated)
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// PathLogin страница входа
+const PathLogin = "/login"
+
+// errLoginRequired запрос с prompt=none без активной сессии
+var errLoginRequired = errors.New("login_required")
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// loginPage данные шаблона страницы входа
+type loginPage struct {
+	Title     string
+	Error     string
+	Notice    string
+	Username  string
+	ReturnTo  string
+	CSRFToken string
+}
+
+// Login godoc
+// @Summary Страница входа
+// @Description HTML форма входа. После успешного входа создается сессия и выполняется возврат на return_to.
+// @Description Пользователю с включенным TOTP или ключами доступа показывается второй шаг входа (/login/mfa).
+// @Description Вместо пароля можно войти по ключу доступа через /login/passkey/begin и /login/passkey/finish.
+// @Description Вошедшему пользователю без return_to страница предлагает зарегистрировать ключ доступа
+// @Tags authorize
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param return_to query string false "Относительный адрес для возврата после входа"
+// @Success 200 {string} string "HTML-форма"
+// @Success 303 "Вход выполнен, редирект на return_to"
+// @Failure 401 {string} string "Неверные учетные данные"
+// @Router /login [get]
+// @Router /login [post]
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		returnTo := safeReturnTo(r.URL.Query().Get("return_to"))
+		if returnTo == "" {
+			if session := h.currentSession(r); session != nil {
+				h.renderSignedIn(w, r, session.UserID)
+				return
+			}
+		}
+		h.renderLogin(w, r, loginPage{ReturnTo: returnTo}, http.StatusOK)
+	case http.MethodPost:
+		h.handleLogin(w, r)
+	default:
+		h.writeErrorResponse(w, "invalid_request", "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogin проверяет учетные данные из формы и создает сессию
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	page := loginPage{
+		Username: r.PostFormValue("username"),
+		ReturnTo: safeReturnTo(r.PostFormValue("return_to")),
+	}
+
+	if !h.validCSRFToken(r) {
+		h.logger.Warn("Login CSRF token mismatch", "ip", clientIP(r))
+		page.Error = "Срок действия формы истек, попробуйте еще раз"
+		h.renderLogin(w, r, page, http.StatusForbidden)
+		return
+	}
+
+	user, err := h.authenticateUser(ctx, page.Username, r.PostFormValue("password"), clientIP(r))
+	if errors.Is(err, errLoginLocked) {
+		setLoginRetryAfter(w, err)
+		page.Error = "Слишком много неудачных попыток входа, попробуйте позже"
+		h.renderLogin(w, r, page, http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, errEmailNotVerified) {
+		page.Error = "Адрес электронной почты не подтвержден. Перейдите по ссылке из письма"
+		h.renderLogin(w, r, page, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.logger.Warn("Login failed", "username", page.Username, "ip", clientIP(r), "error", err)
+		page.Error = "Неверное имя пользователя или пароль"
+		h.renderLogin(w, r, page, http.StatusUnauthorized)
+		return
+	}
+
+	amr, err := h.secondFactor(ctx, user, "", clientIP(r))
+	if errors.Is(err, errOTPRequired) {
+		h.startMFAChallenge(w, user.ID)
+		h.renderMFAChallenge(w, r, user.ID, mfaPage{ReturnTo: page.ReturnTo}, http.StatusOK)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to check second factor", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+
+	h.completeLogin(w, r, user, amr, page.ReturnTo)
+}
+
+// completeLogin создает сессию после всех шагов входа и возвращает пользователя на returnTo
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, amr []string, returnTo string) {
+	if _, err := h.startSession(w, r, user.ID, amr); err != nil {
+		h.logger.Error("Failed to start session", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("User logged in", "user_id", user.ID, "username", user.Username, "amr", amr)
+
+	if returnTo == "" {
+		h.renderLogin(w, r, loginPage{Notice: "Вы вошли как " + user.Username}, http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// renderSignedIn отображает страницу вошедшего пользователя
+func (h *Handler) renderSignedIn(w http.ResponseWriter, r *http.Request, userID string) {
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render login page", http.StatusInternalServerError)
+		return
+	}
+	h.renderLogin(w, r, loginPage{Notice: "Вы вошли как " + user.Username}, http.StatusOK)
+}
+
+// renderLogin отображает форму входа с новым или текущим CSRF токеном
+func (h *Handler) renderLogin(w http.ResponseWriter, r *http.Request, page loginPage, statusCode int) {
+	token, err := h.csrfToken(w, r)
+	if err != nil {
+		h.logger.Error("Failed to generate CSRF token", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render login page", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Вход"
+	page.CSRFToken = token
+	h.renderTemplate(w, "login.html", page, statusCode)
+}
+
+// renderTemplate отображает HTML шаблон. Страницы не кешируются и не встраиваются во фреймы
+func (h *Handler) renderTemplate(w http.ResponseWriter, name string, data interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'self'; connect-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(statusCode)
+
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		h.logger.Error("Failed to render template", "template", name, "error", err)
+	}
+}
+
+// loginRedirect отправляет пользователя на страницу входа с возвратом на текущий запрос
+func loginRedirect(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{"return_to": {r.URL.RequestURI()}}
+	http.Redirect(w, r, PathLogin+"?"+query.Encode(), http.StatusFound)
+}
+
+// safeReturnTo допускает только относительные адреса этого сервера, чтобы форма входа
+// не стала открытым редиректом
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.ContainsAny(returnTo, "\\\r\n") {
+		return ""
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+	return returnTo
+}
+
+// authorizeUser определяет пользователя запроса авторизации. Без сессии браузер отправляется
+// на страницу входа, после которой исходный запрос /authorize повторяется. Вошедший пользователь
+// подтверждает запрошенные scope на экране согласия.
+func (h *Handler) authorizeUser(w http.ResponseWriter, r *http.Request) (string, error) {
+	// Библиотека проверяет права клиента и scope только перед выдачей кода, а не до входа и согласия
+	client, err := h.store.GetClient(r.Context(), r.FormValue("client_id"))
+	if err != nil {
+		return "", oauthErrors.ErrInvalidClient
+	}
+	if err := h.checkResponseType(client, oauth2.ResponseType(r.FormValue("response_type"))); err != nil {
+		return "", err
+	}
+	scopes, err := h.grantScope(r.Context(), client, r.FormValue("scope"))
+	if err != nil {
+		return "", err
+	}
+
+	if auth, ok := authenticationFrom(r); ok && auth.userID != "" {
+		return h.authorizeConsent(w, r, auth.userID, client, scopes)
+	}
+
+	if r.FormValue("prompt") == "none" {
+		return "", errLoginRequired
+	}
+
+	if r.Method != http.MethodGet {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return "", nil
+	}
+
+	loginRedirect(w, r)
+	return "", nil
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Прогрессивная задержка между попытками входа: 250 мс после первой ошибки, далее удваивается до 5 секунд
+const (
+	loginDelayBase = 250 * time.Millisecond
+	loginDelayMax  = 5 * time.Second
+)
+
+// errLoginLocked вход отклонен: имя пользователя или IP адрес заблокированы после неудачных попыток
+// или повторная попытка сделана раньше прогрессивной задержки
+var errLoginLocked = errors.New("too many failed login attempts")
+
+// loginRejection отклоненный вход с временем, после которого можно повторить попытку.
+// Совпадает с errLoginLocked в errors.Is
+type loginRejection struct {
+	until time.Time
+}
+
+func (e *loginRejection) Error() string {
+	return errLoginLocked.Error()
+}
+
+func (e *loginRejection) Is(target error) bool {
+	return target == errLoginLocked
+}
+
+// loginRetryAfter значение заголовка Retry-After в секундах для входа, отклоненного guardLogin
+func loginRetryAfter(err error) (string, bool) {
+	var rejection *loginRejection
+	if !errors.As(err, &rejection) {
+		return "", false
+	}
+	seconds := int(math.Ceil(time.Until(rejection.until).Seconds()))
+	return strconv.Itoa(max(seconds, 1)), true
+}
+
+// setLoginRetryAfter выставляет заголовок Retry-After для входа, отклоненного guardLogin
+func setLoginRetryAfter(w http.ResponseWriter, err error) {
+	if retryAfter, ok := loginRetryAfter(err); ok {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+}
+
+var (
+	loginFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "oauth2_login_failures_total",
+			Help: "Total number of failed user logins",
+		},
+	)
+
+	loginLockoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oauth2_login_lockouts_total",
+			Help: "Total number of login lockouts after repeated failures",
+		},
+		[]string{"subject_type"},
+	)
+
+	loginRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "oauth2_login_rejected_total",
+			Help: "Total number of logins rejected while locked or throttled",
+		},
+		[]string{"subject_type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(loginFailuresTotal)
+	prometheus.MustRegister(loginLockoutsTotal)
+	prometheus.MustRegister(loginRejectedTotal)
+}
+
+// passwordGrantContextKey ключ контекста запроса password grant
+type passwordGrantContextKey struct{}
+
+// passwordGrant данные запроса password grant, которых нет в аргументах обработчика пароля
+// библиотеки. amr заполняет обработчик пароля и читает extractAuthentication
+type passwordGrant struct {
+	ip  string
+	otp string
+	amr []string
+}
+
+// withPasswordGrant сохраняет данные запроса password grant в контексте
+func withPasswordGrant(r *http.Request) *http.Request {
+	grant := &passwordGrant{ip: clientIP(r), otp: r.PostFormValue("otp")}
+	return r.WithContext(context.WithValue(r.Context(), passwordGrantContextKey{}, grant))
+}
+
+// passwordGrantFrom данные запроса password grant из контекста
+func passwordGrantFrom(ctx context.Context) *passwordGrant {
+	grant, _ := ctx.Value(passwordGrantContextKey{}).(*passwordGrant)
+	return grant
+}
+
+// authenticateUser проверяет логин и пароль с защитой от перебора. Счетчик неудачных входов
+// сбрасывает secondFactor, когда вход завершен. При REQUIRE_VERIFIED_EMAIL пользователь
+// с неподтвержденным адресом получает errEmailNotVerified после проверки пароля
+func (h *Handler) authenticateUser(ctx context.Context, username, password, ip string) (*models.User, error) {
+	if err := h.guardLogin(ctx, username, ip); err != nil {
+		return nil, err
+	}
+
+	user, err := h.store.ValidateUser(ctx, username, password)
+	if err != nil {
+		h.loginFailed(ctx, username, ip)
+		return nil, err
+	}
+	if h.config.RequireVerifiedEmail && !user.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+	return user, nil
+}
+
+// guardLogin отклоняет вход заблокированного имени пользователя или IP адреса, а после неудачных
+// попыток — вход раньше прогрессивной задержки. Запрос не ждет задержку, а сразу получает
+// loginRejection со временем повторной попытки. При превышении лимита субъект блокируется
+// на LoginLockoutDuration
+func (h *Handler) guardLogin(ctx context.Context, username, ip string) error {
+	window := h.config.LoginFailureWindow
+
+	userFailures, err := h.store.GetLoginFailures(ctx, storage.LoginSubjectUser, username, window)
+	if err != nil {
+		return err
+	}
+	ipFailures, err := h.store.GetLoginFailures(ctx, storage.LoginSubjectIP, ip, window)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, failures := range []*models.LoginFailures{userFailures, ipFailures} {
+		if failures != nil && failures.LockedUntil != nil && failures.LockedUntil.After(now) {
+			loginRejectedTotal.WithLabelValues(failures.SubjectType).Inc()
+			h.logger.Warn("Login rejected while locked",
+				"subject_type", failures.SubjectType,
+				"username", username,
+				"ip", ip,
+				"locked_until", failures.LockedUntil,
+			)
+			return &loginRejection{until: *failures.LockedUntil}
+		}
+	}
+
+	for _, failures := range []*models.LoginFailures{userFailures, ipFailures} {
+		if failures == nil {
+			continue
+		}
+		if retryAt := failures.LastFailureAt.Add(loginDelay(failures.Failures)); retryAt.After(now) {
+			loginRejectedTotal.WithLabelValues(failures.SubjectType).Inc()
+			h.logger.Info("Login throttled",
+				"subject_type", failures.SubjectType,
+				"username", username,
+				"ip", ip,
+				"retry_at", retryAt,
+			)
+			return &loginRejection{until: retryAt}
+		}
+	}
+	return nil
+}
+
+// loginFailed учитывает неудачный вход для имени пользователя и IP адреса
+func (h *Handler) loginFailed(ctx context.Context, username, ip string) {
+	loginFailuresTotal.Inc()
+	h.recordLoginFailure(ctx, storage.LoginSubjectUser, username, h.config.LoginMaxFailures, username, ip)
+	h.recordLoginFailure(ctx, storage.LoginSubjectIP, ip, h.config.LoginIPMaxFailures, username, ip)
+}
+
+// loginSucceeded сбрасывает счетчик имени пользователя, но не IP адреса: иначе перебор
+// с одного адреса можно было бы чередовать со входом в собственную учетную запись
+func (h *Handler) loginSucceeded(ctx context.Context, user *models.User) {
+	if _, err := h.store.ResetLoginFailures(ctx, storage.LoginSubjectUser, user.Username); err != nil {
+		h.logger.Error("Failed to reset login failures", "user_id", user.ID, "error", err)
+	}
+}
+
+// recordLoginFailure учитывает неудачный вход субъекта и блокирует его при достижении лимита.
+// Лимит 0 отключает учет для субъекта
+func (h *Handler) recordLoginFailure(ctx context.Context, subjectType, subject string, maxFailures int, username, ip string) {
+	if maxFailures <= 0 {
+		return
+	}
+
+	failures, err := h.store.RecordLoginFailure(ctx, subjectType, subject, h.config.LoginFailureWindow, maxFailures, h.config.LoginLockoutDuration)
+	if err != nil {
+		h.logger.Error("Failed to record login failure", "subject_type", subjectType, "error", err)
+		return
+	}
+
+	if failures.Failures >= maxFailures {
+		loginLockoutsTotal.WithLabelValues(subjectType).Inc()
+		h.logger.Warn("Login locked after repeated failures",
+			"event", "login_lockout",
+			"subject_type", subjectType,
+			"username", username,
+			"ip", ip,
+			"failures", failures.Failures,
+			"locked_until", failures.LockedUntil,
+		)
+	}
+}
+
+// passwordAuthorization проверяет учетные данные password grant и, если он настроен, второй фактор
+// из параметра otp. Неверный пароль — invalid_grant (RFC 6749 §5.2), блокировка и отсутствие
+// второго фактора сообщаются отдельными описаниями
+func (h *Handler) passwordAuthorization(ctx context.Context, clientID, username, password string) (string, error) {
+	grant := passwordGrantFrom(ctx)
+	if grant == nil {
+		grant = &passwordGrant{}
+	}
+
+	user, err := h.authenticateUser(ctx, username, password, grant.ip)
+	if errors.Is(err, errLoginLocked) || errors.Is(err, errEmailNotVerified) {
+		return "", err
+	}
+	if err != nil {
+		h.logger.Warn("Password grant failed", "client_id", clientID, "username", username, "error", err)
+		return "", nil
+	}
+
+	amr, err := h.secondFactor(ctx, user, grant.otp, grant.ip)
+	if errors.Is(err, errLoginLocked) || errors.Is(err, errOTPRequired) {
+		return "", err
+	}
+	if err != nil {
+		h.logger.Warn("Password grant second factor failed", "client_id", clientID, "user_id", user.ID, "error", err)
+		return "", nil
+	}
+
+	grant.amr = amr
+	return user.ID, nil
+}
+
+// loginDelay задержка перед следующей попыткой входа после count недавних ошибок
+func loginDelay(count int) time.Duration {
+	if count <= 0 {
+		return 0
+	}
+
+	delay := loginDelayBase
+	for i := 1; i < count && delay < loginDelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, loginDelayMax)
+}
@@ -0,0 +1,580 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/mfa"
+	"go_oauth2_server/internal/models"
+)
+
+// Маршруты второго фактора
+const (
+	// PathLoginMFA второй шаг входа через страницу /login
+	PathLoginMFA = "/login/mfa"
+	// PathMFATOTP настройка TOTP текущего пользователя
+	PathMFATOTP = "/mfa/totp"
+	// PathMFARecoveryCodes новый набор кодов восстановления
+	PathMFARecoveryCodes = "/mfa/recovery-codes"
+)
+
+// Методы аутентификации (RFC 8176 §2)
+const (
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+)
+
+const (
+	// mfaCookieName cookie с пользователем, прошедшим проверку пароля и ожидающим второй фактор
+	mfaCookieName = "oauth2_mfa"
+	// mfaChallengeLifetime время на ввод второго фактора после пароля
+	mfaChallengeLifetime = 5 * time.Minute
+)
+
+var (
+	// errOTPRequired у пользователя настроен второй фактор, а код не передан
+	errOTPRequired = errors.New("one-time password is required")
+	// errInvalidOTP неверный или уже использованный код второго фактора
+	errInvalidOTP = errors.New("invalid one-time password")
+)
+
+// mfaPage данные шаблона второго шага входа. TOTP и Passkey — доступные пользователю способы подтверждения
+type mfaPage struct {
+	Title     string
+	Error     string
+	ReturnTo  string
+	CSRFToken string
+	TOTP      bool
+	Passkey   bool
+}
+
+// secondFactors определяет, какие вторые факторы настроены у пользователя
+func (h *Handler) secondFactors(ctx context.Context, userID string) (*models.TOTP, bool, error) {
+	totp, err := h.store.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if totp != nil && totp.ConfirmedAt == nil {
+		totp = nil
+	}
+
+	passkeys, err := h.store.CountPasskeys(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return totp, passkeys > 0, nil
+}
+
+// secondFactor завершает вход пользователя, прошедшего проверку пароля. Без TOTP и ключей доступа
+// вход завершается сразу, иначе проверяется code с той же защитой от перебора, что и пароль.
+// Ключ доступа подтверждается только на странице входа, поэтому пользователю с одними ключами
+// без TOTP вход по коду недоступен. Возвращает методы аутентификации для claim amr
+func (h *Handler) secondFactor(ctx context.Context, user *models.User, code, ip string) ([]string, error) {
+	totp, passkey, err := h.secondFactors(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if totp == nil && !passkey {
+		h.loginSucceeded(ctx, user)
+		return []string{amrPassword}, nil
+	}
+
+	if code == "" {
+		return nil, errOTPRequired
+	}
+	if err := h.guardLogin(ctx, user.Username, ip); err != nil {
+		return nil, err
+	}
+
+	valid := false
+	if totp != nil {
+		if valid, err = h.verifyOTP(ctx, totp, code); err != nil {
+			return nil, err
+		}
+	}
+	if !valid {
+		h.loginFailed(ctx, user.Username, ip)
+		return nil, errInvalidOTP
+	}
+
+	h.loginSucceeded(ctx, user)
+	return []string{amrPassword, amrOTP}, nil
+}
+
+// verifyOTP проверяет код TOTP или код восстановления и отмечает его использованным
+func (h *Handler) verifyOTP(ctx context.Context, totp *models.TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if _, err := strconv.Atoi(code); err != nil {
+		used, err := h.store.UseRecoveryCode(ctx, totp.UserID, mfa.NormalizeRecoveryCode(code))
+		if used {
+			h.logger.Info("Recovery code used", "user_id", totp.UserID)
+		}
+		return used, err
+	}
+
+	secret, err := h.mfaCipher.Decrypt(totp.Secret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := mfa.ValidateTOTP(secret, code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	return h.store.UseTOTPStep(ctx, totp.UserID, step)
+}
+
+// startMFAChallenge запоминает пользователя, прошедшего проверку пароля, до ввода второго фактора
+func (h *Handler) startMFAChallenge(w http.ResponseWriter, userID string) {
+	expiresAt := time.Now().Add(mfaChallengeLifetime)
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    h.signValue(userID + "|" + strconv.FormatInt(expiresAt.Unix(), 10)),
+		Path:     PathLoginMFA,
+		Expires:  expiresAt,
+		MaxAge:   int(mfaChallengeLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   h.config.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// mfaChallengeUser пользователь незавершенного входа из cookie
+func (h *Handler) mfaChallengeUser(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(mfaCookieName)
+	if err != nil {
+		return "", false
+	}
+	value, ok := h.verifySignedValue(cookie.Value)
+	if !ok {
+		return "", false
+	}
+
+	userID, expires, found := strings.Cut(value, "|")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if !found || err != nil || time.Now().Unix() > expiresAt {
+		return "", false
+	}
+	return userID, true
+}
+
+// clearMFAChallenge удаляет cookie незавершенного входа
+func (h *Handler) clearMFAChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    "",
+		Path:     PathLoginMFA,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.config.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// LoginMFA godoc
+// @Summary Второй шаг входа
+// @Description Проверяет код TOTP или код восстановления пользователя, прошедшего проверку пароля на /login, и создает сессию.
+// @Description Ключом доступа вход подтверждается через /login/mfa/passkey/begin и /login/mfa/passkey/finish
+// @Tags authorize
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param code formData string true "Код TOTP или код восстановления"
+// @Success 303 "Вход выполнен, редирект на return_to"
+// @Failure 401 {string} string "Неверный код или истек срок подтверждения"
+// @Failure 429 {string} string "Слишком много неудачных попыток"
+// @Router /login/mfa [post]
+func (h *Handler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	page := mfaPage{ReturnTo: safeReturnTo(r.PostFormValue("return_to"))}
+
+	if !h.validCSRFToken(r) {
+		h.logger.Warn("MFA CSRF token mismatch", "ip", clientIP(r))
+		page.Error = "Срок действия формы истек, попробуйте еще раз"
+		if userID, ok := h.mfaChallengeUser(r); ok {
+			h.renderMFAChallenge(w, r, userID, page, http.StatusForbidden)
+			return
+		}
+		h.renderMFA(w, r, page, http.StatusForbidden)
+		return
+	}
+
+	userID, ok := h.mfaChallengeUser(r)
+	var user *models.User
+	if ok {
+		var err error
+		if user, err = h.store.GetUserByID(ctx, userID); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		h.clearMFAChallenge(w)
+		loginPage := loginPage{ReturnTo: page.ReturnTo, Error: "Срок подтверждения входа истек, войдите снова"}
+		h.renderLogin(w, r, loginPage, http.StatusUnauthorized)
+		return
+	}
+
+	amr, err := h.secondFactor(ctx, user, r.PostFormValue("code"), clientIP(r))
+	switch {
+	case errors.Is(err, errLoginLocked):
+		setLoginRetryAfter(w, err)
+		h.clearMFAChallenge(w)
+		loginPage := loginPage{ReturnTo: page.ReturnTo, Error: "Слишком много неудачных попыток входа, попробуйте позже"}
+		h.renderLogin(w, r, loginPage, http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errOTPRequired), errors.Is(err, errInvalidOTP):
+		h.logger.Warn("Second factor failed", "user_id", user.ID, "ip", clientIP(r))
+		page.Error = "Неверный код"
+		h.renderMFAChallenge(w, r, user.ID, page, http.StatusUnauthorized)
+		return
+	case err != nil:
+		h.logger.Error("Failed to verify second factor", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to verify second factor", http.StatusInternalServerError)
+		return
+	}
+
+	h.clearMFAChallenge(w)
+	h.completeLogin(w, r, user, amr, page.ReturnTo)
+}
+
+// renderMFAChallenge отображает второй шаг входа со способами подтверждения, настроенными у пользователя
+func (h *Handler) renderMFAChallenge(w http.ResponseWriter, r *http.Request, userID string, page mfaPage, statusCode int) {
+	totp, passkey, err := h.secondFactors(r.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get second factors", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render login page", http.StatusInternalServerError)
+		return
+	}
+
+	page.TOTP = totp != nil
+	page.Passkey = passkey
+	h.renderMFA(w, r, page, statusCode)
+}
+
+// renderMFA отображает форму ввода второго фактора
+func (h *Handler) renderMFA(w http.ResponseWriter, r *http.Request, page mfaPage, statusCode int) {
+	token, err := h.csrfToken(w, r)
+	if err != nil {
+		h.logger.Error("Failed to generate CSRF token", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to render login page", http.StatusInternalServerError)
+		return
+	}
+
+	page.Title = "Подтверждение входа"
+	page.CSRFToken = token
+	h.renderTemplate(w, "mfa.html", page, statusCode)
+}
+
+// TOTPStatus godoc
+// @Summary Состояние второго фактора
+// @Description Включен ли TOTP у текущего пользователя и сколько осталось кодов восстановления. Требуется сессия страницы входа
+// @Tags mfa
+// @Produce json
+// @Success 200 {object} models.TOTPStatus
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Router /mfa/totp [get]
+func (h *Handler) TOTPStatus(w http.ResponseWriter, r *http.Request) {
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	totp, err := h.store.GetTOTP(r.Context(), session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to get TOTP", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to get second factor", http.StatusInternalServerError)
+		return
+	}
+
+	status := models.TOTPStatus{Enabled: totp != nil && totp.ConfirmedAt != nil}
+	if status.Enabled {
+		if status.RecoveryCodesRemaining, err = h.store.CountRecoveryCodes(r.Context(), session.UserID); err != nil {
+			h.logger.Error("Failed to count recovery codes", "user_id", session.UserID, "error", err)
+			h.writeErrorResponse(w, "server_error", "Failed to get second factor", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, status, http.StatusOK)
+}
+
+// EnrollTOTP godoc
+// @Summary Настройка TOTP
+// @Description Создает секрет TOTP и возвращает адрес otpauth:// для приложения-аутентификатора (содержимое QR кода). Второй фактор включается после подтверждения кодом. Требуется сессия страницы входа
+// @Tags mfa
+// @Produce json
+// @Success 201 {object} models.TOTPEnrollment
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 409 {object} map[string]string "Второй фактор уже включен"
+// @Router /mfa/totp [post]
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.store.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to enroll second factor", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate TOTP secret", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to enroll second factor", http.StatusInternalServerError)
+		return
+	}
+	encrypted, err := h.mfaCipher.Encrypt(secret)
+	if err != nil {
+		h.logger.Error("Failed to encrypt TOTP secret", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to enroll second factor", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := h.store.SaveTOTPEnrollment(ctx, user.ID, encrypted)
+	if err != nil {
+		h.logger.Error("Failed to save TOTP enrollment", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to enroll second factor", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		h.writeErrorResponse(w, "conflict", "Second factor is already enabled", http.StatusConflict)
+		return
+	}
+
+	h.logger.Info("TOTP enrollment started", "user_id", user.ID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: mfa.ProvisioningURI(h.config.MFAIssuer, user.Username, secret),
+	}, http.StatusCreated)
+}
+
+// ConfirmTOTP godoc
+// @Summary Подтверждение TOTP
+// @Description Включает второй фактор после проверки первого кода из приложения и возвращает одноразовые коды восстановления. Коды показываются один раз
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param request body models.TOTPCodeRequest true "Код из приложения-аутентификатора"
+// @Success 200 {object} models.RecoveryCodes
+// @Failure 400 {object} map[string]string "Неверный код"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 404 {object} map[string]string "Настройка TOTP не начата"
+// @Failure 409 {object} map[string]string "Второй фактор уже включен"
+// @Router /mfa/totp/confirm [post]
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	totp, err := h.store.GetTOTP(ctx, session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to get TOTP", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to confirm second factor", http.StatusInternalServerError)
+		return
+	}
+	if totp == nil {
+		h.writeErrorResponse(w, "not_found", "TOTP enrollment not found", http.StatusNotFound)
+		return
+	}
+	if totp.ConfirmedAt != nil {
+		h.writeErrorResponse(w, "conflict", "Second factor is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := h.mfaCipher.Decrypt(totp.Secret)
+	if err != nil {
+		h.logger.Error("Failed to decrypt TOTP secret", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to confirm second factor", http.StatusInternalServerError)
+		return
+	}
+	step, ok := mfa.ValidateTOTP(secret, strings.TrimSpace(req.Code), time.Now(), 0)
+	if !ok {
+		h.writeErrorResponse(w, "invalid_request", "Invalid one-time password", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to confirm second factor", http.StatusInternalServerError)
+		return
+	}
+
+	confirmed, err := h.store.ConfirmTOTP(ctx, session.UserID, step, normalizeRecoveryCodes(codes))
+	if err != nil {
+		h.logger.Error("Failed to confirm TOTP", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to confirm second factor", http.StatusInternalServerError)
+		return
+	}
+	if !confirmed {
+		h.writeErrorResponse(w, "conflict", "Second factor is already enabled", http.StatusConflict)
+		return
+	}
+
+	h.logger.Info("TOTP enabled", "event", "mfa_enabled", "user_id", session.UserID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.RecoveryCodes{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// DisableTOTP godoc
+// @Summary Отключение TOTP
+// @Description Отключает второй фактор и удаляет коды восстановления. Требуется действующий код TOTP или код восстановления
+// @Tags mfa
+// @Accept json
+// @Param request body models.TOTPCodeRequest true "Код TOTP или код восстановления"
+// @Success 204 "Второй фактор отключен"
+// @Failure 400 {object} map[string]string "Неверный код"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 404 {object} map[string]string "Второй фактор не включен"
+// @Failure 429 {object} map[string]string "Слишком много неудачных попыток"
+// @Router /mfa/totp [delete]
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireOTP(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.store.DeleteTOTP(r.Context(), user.ID); err != nil {
+		h.logger.Error("Failed to delete TOTP", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to disable second factor", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("TOTP disabled", "event", "mfa_disabled", "user_id", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Новые коды восстановления
+// @Description Заменяет коды восстановления новым набором. Требуется действующий код TOTP или код восстановления
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Param request body models.TOTPCodeRequest true "Код TOTP или код восстановления"
+// @Success 200 {object} models.RecoveryCodes
+// @Failure 400 {object} map[string]string "Неверный код"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 404 {object} map[string]string "Второй фактор не включен"
+// @Failure 429 {object} map[string]string "Слишком много неудачных попыток"
+// @Router /mfa/recovery-codes [post]
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireOTP(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		h.logger.Error("Failed to generate recovery codes", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.ReplaceRecoveryCodes(r.Context(), user.ID, normalizeRecoveryCodes(codes)); err != nil {
+		h.logger.Error("Failed to replace recovery codes", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Recovery codes regenerated", "user_id", user.ID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.RecoveryCodes{RecoveryCodes: codes}, http.StatusOK)
+}
+
+// requireOTP проверяет сессию и код второго фактора из тела запроса перед изменением настроек MFA.
+// Неверные коды учитываются как неудачные входы. При ошибке ответ уже отправлен
+func (h *Handler) requireOTP(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	ctx := r.Context()
+
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := h.store.GetUserByID(ctx, session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to get user", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to verify second factor", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	totp, err := h.store.GetTOTP(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to get TOTP", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to verify second factor", http.StatusInternalServerError)
+		return nil, false
+	}
+	if totp == nil || totp.ConfirmedAt == nil {
+		h.writeErrorResponse(w, "not_found", "Second factor is not enabled", http.StatusNotFound)
+		return nil, false
+	}
+
+	if err := h.guardLogin(ctx, user.Username, clientIP(r)); err != nil {
+		if errors.Is(err, errLoginLocked) {
+			setLoginRetryAfter(w, err)
+			h.writeErrorResponse(w, "access_denied", "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+			return nil, false
+		}
+		h.writeErrorResponse(w, "server_error", "Failed to verify second factor", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	valid, err := h.verifyOTP(ctx, totp, req.Code)
+	if err != nil {
+		h.logger.Error("Failed to verify second factor", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to verify second factor", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !valid {
+		h.loginFailed(ctx, user.Username, clientIP(r))
+		h.writeErrorResponse(w, "invalid_request", "Invalid one-time password", http.StatusBadRequest)
+		return nil, false
+	}
+	return user, true
+}
+
+// normalizeRecoveryCodes коды восстановления в виде, в котором хранятся их хеши
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = mfa.NormalizeRecoveryCode(code)
+	}
+	return normalized
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/jwt"
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-oauth2/oauth2/v4"
+	jwtLib "github.com/golang-jwt/jwt/v5"
+)
+
+// Scope OpenID Connect (OpenID Connect Core §5.4)
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+	scopePhone   = "phone"
+	scopeAddress = "address"
+)
+
+// authenticationContextKey ключ контекста запроса с данными аутентификации пользователя
+type authenticationContextKey struct{}
+
+// authentication данные аутентификации пользователя. nonce и время аутентификации
+// попадают в ID токен через расширения кода или токена
+type authentication struct {
+	userID   string
+	nonce    string
+	authTime time.Time
+	// amr методы аутентификации пользователя (RFC 8176)
+	amr []string
+}
+
+// withAuthentication сохраняет данные аутентификации в контексте запроса
+func withAuthentication(r *http.Request, auth authentication) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticationContextKey{}, auth))
+}
+
+// authenticationFrom возвращает данные аутентификации из контекста запроса
+func authenticationFrom(r *http.Request) (authentication, bool) {
+	auth, ok := r.Context().Value(authenticationContextKey{}).(authentication)
+	return auth, ok
+}
+
+// extractAuthentication переносит nonce и время аутентификации из контекста запроса в расширения
+// выдаваемого кода или токена. При обмене кода контекст пуст, а значения уже скопированы из кода.
+func extractAuthentication(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil {
+		return
+	}
+	auth, ok := authenticationFrom(tgr.Request)
+	if !ok {
+		return
+	}
+	// В password grant методы аутентификации известны только после проверки пароля библиотекой
+	if grant := passwordGrantFrom(tgr.Request.Context()); grant != nil {
+		auth.amr = grant.amr
+	}
+
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = make(url.Values)
+	}
+	if auth.nonce != "" {
+		ext.Set(jwt.ExtensionNonce, auth.nonce)
+	}
+	ext.Set(jwt.ExtensionAuthTime, strconv.FormatInt(auth.authTime.Unix(), 10))
+	if len(auth.amr) > 0 {
+		ext.Set(jwt.ExtensionAMR, strings.Join(auth.amr, " "))
+	}
+	ti.SetExtension(ext)
+}
+
+// idTokenRequired сообщает, нужен ли ID токен в ответе токен-эндпоинта: токен выдан пользователю со scope openid
+func idTokenRequired(ti oauth2.TokenInfo) bool {
+	return ti.GetUserID() != "" && hasScope(ti.GetScope(), scopeOpenID)
+}
+
+// revokeIssuedToken отзывает токены, выданные в ответ на запрос, который не удалось завершить
+func (h *Handler) revokeIssuedToken(ctx context.Context, ti oauth2.TokenInfo) {
+	token, hint := ti.GetAccess(), tokenTypeHintAccess
+	if refresh := ti.GetRefresh(); refresh != "" {
+		// Отзыв refresh токена отзывает и выданный вместе с ним access токен
+		token, hint = refresh, tokenTypeHintRefresh
+	}
+	if _, err := h.revokeToken(ctx, ti.GetClientID(), token, hint); err != nil {
+		h.logger.Error("Failed to revoke issued token", "client_id", ti.GetClientID(), "error", err)
+	}
+}
+
+// openIDAvailable сообщает, можно ли выдавать ID токены. Они подписываются только асимметричным ключом:
+// проверка подписи HS256 потребовала бы раздать клиентам JWT_SECRET, которым подписаны все токены сервера
+func (h *Handler) openIDAvailable() bool {
+	return h.keys.SigningKey().IsAsymmetric()
+}
+
+// issueIDToken выпускает ID токен (OpenID Connect Core §2) для выданного access токена
+func (h *Handler) issueIDToken(ctx context.Context, ti oauth2.TokenInfo) (string, error) {
+	user, err := h.store.GetUserByID(ctx, ti.GetUserID())
+	if err != nil {
+		return "", err
+	}
+
+	key := h.keys.SigningKey()
+	if !key.IsAsymmetric() {
+		return "", errors.New("ID tokens require an asymmetric signing key")
+	}
+	now := time.Now()
+
+	claims := jwtLib.MapClaims{
+		"iss": h.config.Issuer,
+		"sub": user.ID,
+		"aud": ti.GetClientID(),
+		"exp": now.Add(ti.GetAccessExpiresIn()).Unix(),
+		"iat": now.Unix(),
+	}
+
+	var ext url.Values
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok {
+		ext = eti.GetExtension()
+	}
+	if authTime, err := strconv.ParseInt(ext.Get(jwt.ExtensionAuthTime), 10, 64); err == nil {
+		claims["auth_time"] = authTime
+	}
+	if nonce := ext.Get(jwt.ExtensionNonce); nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if amr := strings.Fields(ext.Get(jwt.ExtensionAMR)); len(amr) > 0 {
+		claims["amr"] = amr
+	}
+
+	atHash, err := jwt.TokenHash(key.Method, ti.GetAccess())
+	if err != nil {
+		return "", err
+	}
+	claims["at_hash"] = atHash
+
+	if code := ext.Get(jwt.ExtensionAuthorizationCode); code != "" {
+		cHash, err := jwt.TokenHash(key.Method, code)
+		if err != nil {
+			return "", err
+		}
+		claims["c_hash"] = cHash
+	}
+
+	for name, value := range userClaims(user, ti.GetScope()) {
+		claims[name] = value
+	}
+
+	return key.Sign(claims)
+}
+
+// userClaims claims пользователя, разрешенные выданными scope (OpenID Connect Core §5.4)
+func userClaims(user *models.User, scope string) map[string]interface{} {
+	claims := make(map[string]interface{})
+	setClaim := func(name, value string) {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+
+	if hasScope(scope, scopeProfile) {
+		setClaim("name", user.Name)
+		setClaim("given_name", user.GivenName)
+		setClaim("family_name", user.FamilyName)
+		setClaim("nickname", user.Nickname)
+		setClaim("preferred_username", user.Username)
+		setClaim("picture", user.Picture)
+		setClaim("locale", user.Locale)
+		setClaim("zoneinfo", user.Zoneinfo)
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+
+	if hasScope(scope, scopeEmail) && user.Email != "" {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+
+	if hasScope(scope, scopePhone) && user.PhoneNumber != "" {
+		claims["phone_number"] = user.PhoneNumber
+		claims["phone_number_verified"] = user.PhoneNumberVerified
+	}
+
+	if hasScope(scope, scopeAddress) && user.Address != nil {
+		claims["address"] = user.Address
+	}
+
+	return claims
+}
+
+// hasScope проверяет наличие значения в списке scope, разделенном пробелами
+func hasScope(scope, name string) bool {
+	return slices.Contains(strings.Fields(scope), name)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go_oauth2_server/internal/ratelimit"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RateLimitGroup группа маршрутов с общим лимитом запросов
+type RateLimitGroup string
+
+// Группы маршрутов с отдельными лимитами
+const (
+	RateLimitToken      RateLimitGroup = "token"
+	RateLimitAuthorize  RateLimitGroup = "authorize"
+	RateLimitIntrospect RateLimitGroup = "introspect"
+	RateLimitManagement RateLimitGroup = "management"
+)
+
+var rateLimitedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "oauth2_rate_limited_total",
+		Help: "Total number of requests rejected by rate limiting",
+	},
+	[]string{"group", "key_type"},
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitedTotal)
+}
+
+// RateLimit ограничивает частоту запросов группы маршрутов с одного IP адреса, а для групп
+// клиентских эндпоинтов — еще и запросов одного client_id с этого адреса. Ошибка хранилища корзин
+// не блокирует запрос
+func (h *Handler) RateLimit(group RateLimitGroup) func(http.Handler) http.Handler {
+	ipLimit := h.groupLimit(group)
+	clientLimit := h.config.RateLimitClient
+	limitClient := group != RateLimitManagement
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !h.allowRequest(w, r, group, "ip", string(group)+":ip:"+clientIP(r), ipLimit) {
+				return
+			}
+			if limitClient {
+				// Лимит клиента общий для всех групп: он ограничивает клиента, а не отдельный эндпоинт.
+				// client_id здесь еще не аутентифицирован, поэтому корзина ведется и по IP адресу:
+				// иначе запросы с чужим client_id с разных адресов исчерпали бы лимит настоящего клиента.
+				// client_id хешируется: ключ корзины не должен зависеть от длины присланного значения
+				if clientID := requestClientID(r); clientID != "" {
+					sum := sha256.Sum256([]byte(clientID))
+					key := "client:" + hex.EncodeToString(sum[:]) + ":ip:" + clientIP(r)
+					if !h.allowRequest(w, r, group, "client", key, clientLimit) {
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// groupLimit лимит запросов с одного IP адреса для группы маршрутов
+func (h *Handler) groupLimit(group RateLimitGroup) ratelimit.Limit {
+	switch group {
+	case RateLimitToken:
+		return h.config.RateLimitToken
+	case RateLimitAuthorize:
+		return h.config.RateLimitAuthorize
+	case RateLimitIntrospect:
+		return h.config.RateLimitIntrospect
+	default:
+		return h.config.RateLimitManagement
+	}
+}
+
+// allowRequest списывает запрос из корзины key. При превышении лимита отправляет 429
+// с заголовком Retry-After и возвращает false
+func (h *Handler) allowRequest(w http.ResponseWriter, r *http.Request, group RateLimitGroup, keyType, key string, limit ratelimit.Limit) bool {
+	if !limit.Enabled() {
+		return true
+	}
+
+	allowed, retryAfter, err := h.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		h.logger.Error("Rate limiter failed", "group", group, "error", err)
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	rateLimitedTotal.WithLabelValues(string(group), keyType).Inc()
+	h.logger.Warn("Rate limit exceeded",
+		"group", group,
+		"key", key,
+		"path", r.URL.Path,
+		"retry_after", retryAfter,
+	)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+	h.writeErrorResponse(w, "rate_limit_exceeded", "Too many requests, retry later", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// validateRedirectURI проверяет redirect URI при регистрации клиента (RFC 6749 §3.1.2):
+// адрес должен быть абсолютным и не содержать фрагмента
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return errors.New("redirect URI is not a valid URL")
+	}
+	if !u.IsAbs() {
+		return errors.New("redirect URI must be absolute")
+	}
+	if u.Fragment != "" || u.RawFragment != "" {
+		return errors.New("redirect URI must not contain a fragment")
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.Host == "" {
+		return errors.New("redirect URI must contain a host")
+	}
+	return nil
+}
+
+// matchRedirectURI ищет redirect URI запроса среди зарегистрированных. Адреса сравниваются
+// посимвольно, кроме loopback адресов нативных приложений (RFC 8252 §7.3)
+func matchRedirectURI(registered []string, redirectURI string) bool {
+	if slices.Contains(registered, redirectURI) {
+		return true
+	}
+
+	requested, ok := loopbackRedirectURI(redirectURI)
+	if !ok {
+		return false
+	}
+	for _, uri := range registered {
+		if candidate, ok := loopbackRedirectURI(uri); ok && candidate == requested {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackRedirectURI возвращает http адрес на loopback IP без порта. Нативное приложение
+// получает порт от ОС в момент запроса, поэтому порт при сравнении не учитывается
+func loopbackRedirectURI(redirectURI string) (string, bool) {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme != "http" {
+		return "", false
+	}
+
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil || !ip.IsLoopback() {
+		return "", false
+	}
+
+	u.Host = u.Hostname()
+	if ip.To4() == nil {
+		u.Host = "[" + u.Host + "]"
+	}
+	return u.String(), true
+}
+
+// validateAuthorizeRedirect проверяет клиента и redirect URI запроса авторизации. Ошибки
+// возвращаются напрямую, а не редиректом: непроверенный адрес не должен получать ответ
+// (RFC 6749 §4.1.2.1). Без redirect_uri используется единственный зарегистрированный адрес.
+func (h *Handler) validateAuthorizeRedirect(w http.ResponseWriter, r *http.Request) bool {
+	client, err := h.store.GetClient(r.Context(), r.FormValue("client_id"))
+	if err != nil {
+		h.writeErrorResponse(w, "invalid_client", "Unknown client", http.StatusBadRequest)
+		return false
+	}
+
+	redirectURI := r.FormValue("redirect_uri")
+	if redirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			h.writeErrorResponse(w, "invalid_request", "Missing redirect_uri", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+
+	if !matchRedirectURI(client.RedirectURIs, redirectURI) {
+		h.logger.Warn("Redirect URI is not registered", "client_id", client.ID, "redirect_uri", redirectURI)
+		h.writeErrorResponse(w, "invalid_request", "Redirect URI is not registered for the client", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// authorizeRefresh проверяет запрос grant_type=refresh_token до передачи библиотеке, которая
+// не проверяет ни секрет клиента, ни принадлежность токена. Конфиденциальный клиент должен
+// аутентифицироваться, а refresh токен должен быть выдан этому клиенту (RFC 6749 §6).
+// При ошибке ответ уже отправлен.
+func (h *Handler) authorizeRefresh(w http.ResponseWriter, r *http.Request) bool {
+	if oauth2.GrantType(r.FormValue("grant_type")) != oauth2.Refreshing {
+		return true
+	}
+
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.logger.Warn("Refresh token client authentication failed", "client_id", requestClientID(r))
+		h.writeInvalidClient(w)
+		return false
+	}
+
+	// Повторное предъявление токена, замененного при ротации, отзывает все семейство
+	reused, err := h.store.RevokeReusedRefreshToken(r.Context(), client.ID, r.FormValue("refresh_token"))
+	if err != nil {
+		h.logger.Error("Failed to check refresh token reuse", "client_id", client.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to refresh token", http.StatusInternalServerError)
+		return false
+	}
+	if reused {
+		h.writeErrorResponse(w, "invalid_grant", "Refresh token has already been used", http.StatusBadRequest)
+		return false
+	}
+
+	// Неизвестный токен отклонит библиотека с invalid_grant
+	ti, err := h.srv.Manager.LoadRefreshToken(r.Context(), r.FormValue("refresh_token"))
+	if err != nil {
+		return true
+	}
+
+	if ti.GetClientID() != client.ID {
+		h.logger.Warn("Refresh token presented by another client",
+			"client_id", client.ID,
+			"token_client_id", ti.GetClientID(),
+		)
+		h.writeErrorResponse(w, "invalid_grant", "Refresh token was issued to another client", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
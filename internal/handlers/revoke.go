@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+const (
+	tokenTypeHintAccess  = "access_token"
+	tokenTypeHintRefresh = "refresh_token"
+)
+
+// Revoke godoc
+// @Summary Отзыв токена
+// @Description Отзыв access или refresh токена (RFC 7009). Требует аутентификации клиента
+// @Tags token
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Отзываемый токен"
+// @Param token_type_hint formData string false "access_token или refresh_token"
+// @Success 200 "Токен отозван или недействителен"
+// @Failure 400 {object} map[string]string "Неверный запрос"
+// @Failure 401 {object} map[string]string "Ошибка аутентификации клиента"
+// @Router /revoke [post]
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		h.writeErrorResponse(w, "invalid_request", "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.authenticateClient(r)
+	if err != nil {
+		h.logger.Warn("Revocation client authentication failed", "error", err)
+		h.writeInvalidClient(w)
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		h.writeErrorResponse(w, "invalid_request", "Token parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// Подсказка лишь задает порядок поиска, неизвестные значения игнорируются (RFC 7009 §2.1)
+	revoked, err := h.revokeToken(ctx, client.ID, token, r.PostFormValue("token_type_hint"))
+	if err != nil {
+		h.logger.Error("Token revocation failed", "client_id", client.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Token revocation failed", http.StatusInternalServerError)
+		return
+	}
+
+	if revoked {
+		h.logger.Info("Token revoked", "client_id", client.ID)
+	}
+
+	// Недействительный или чужой токен не считается ошибкой (RFC 7009 §2.2)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeToken отзывает токен клиента, сначала проверяя тип из подсказки
+func (h *Handler) revokeToken(ctx context.Context, clientID, token, hint string) (bool, error) {
+	if hint == tokenTypeHintRefresh {
+		revoked, err := h.store.RevokeRefreshToken(ctx, clientID, token)
+		if err != nil || revoked > 0 {
+			return revoked > 0, err
+		}
+		return h.revokeAccessToken(ctx, clientID, token)
+	}
+
+	revoked, err := h.revokeAccessToken(ctx, clientID, token)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	n, err := h.store.RevokeRefreshToken(ctx, clientID, token)
+	return n > 0, err
+}
+
+// revokeAccessToken удаляет access токен из хранилища и вносит JWT в denylist,
+// так как самодостаточный токен остается валидным и без записи в БД
+func (h *Handler) revokeAccessToken(ctx context.Context, clientID, token string) (bool, error) {
+	n, err := h.store.RevokeAccessToken(ctx, clientID, token)
+	if err != nil {
+		return false, err
+	}
+
+	claims, err := h.parseJWTClaims(token)
+	if err != nil {
+		return n > 0, nil
+	}
+
+	aud, _ := claims.GetAudience()
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims.GetExpirationTime()
+	if jti == "" || exp == nil || !slices.Contains(aud, clientID) {
+		return n > 0, nil
+	}
+
+	if err := h.store.RevokeJTI(ctx, jti, exp.Time); err != nil {
+		return false, err
+	}
+	return true, nil
+}
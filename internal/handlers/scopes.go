@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+)
+
+// grantScope сужает запрошенные scope до разрешенных клиенту (RFC 6749 §3.3). Незарегистрированный
+// scope отклоняется с invalid_scope, а без запроса выдаются scope по умолчанию.
+func (h *Handler) grantScope(ctx context.Context, client *models.Client, requested string) ([]models.Scope, error) {
+	registered, err := h.store.ListScopes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := strings.Fields(requested)
+	var granted []models.Scope
+
+	if len(names) == 0 {
+		for _, scope := range registered {
+			if scope.Name == scopeOpenID && !h.openIDAvailable() {
+				continue
+			}
+			if scope.Default && clientAllowsScope(client, scope) {
+				granted = append(granted, scope)
+			}
+		}
+		return granted, nil
+	}
+
+	for _, name := range names {
+		i := slices.IndexFunc(registered, func(scope models.Scope) bool { return scope.Name == name })
+		if i < 0 {
+			h.logger.Warn("Unknown scope requested", "client_id", client.ID, "scope", name)
+			return nil, oauthErrors.ErrInvalidScope
+		}
+		if name == scopeOpenID && !h.openIDAvailable() {
+			h.logger.Warn("OpenID Connect requires an asymmetric signing key", "client_id", client.ID)
+			return nil, oauthErrors.ErrInvalidScope
+		}
+
+		scope := registered[i]
+		duplicate := slices.ContainsFunc(granted, func(g models.Scope) bool { return g.Name == name })
+		if duplicate || !clientAllowsScope(client, scope) {
+			continue
+		}
+		granted = append(granted, scope)
+	}
+	return granted, nil
+}
+
+// registrationScopes проверяет scope, которые клиент запросил при регистрации (RFC 7591 §2).
+// Restricted scope при самостоятельной регистрации не выдаются
+func registrationScopes(registered []models.Scope, requested string) ([]string, error) {
+	names := strings.Fields(requested)
+	for _, name := range names {
+		i := slices.IndexFunc(registered, func(scope models.Scope) bool { return scope.Name == name })
+		if i < 0 {
+			return nil, errors.New("unknown scope: " + name)
+		}
+		if registered[i].Restricted {
+			return nil, errors.New("restricted scope is assigned by administrator: " + name)
+		}
+	}
+	return names, nil
+}
+
+// assignedScopes проверяет scope, которые администратор назначает клиенту. В отличие от
+// registrationScopes допускает restricted scope
+func assignedScopes(registered []models.Scope, requested string) ([]string, error) {
+	names := strings.Fields(requested)
+	for _, name := range names {
+		if !slices.ContainsFunc(registered, func(scope models.Scope) bool { return scope.Name == name }) {
+			return nil, errors.New("unknown scope: " + name)
+		}
+	}
+	return names, nil
+}
+
+// clientAllowsScope проверяет, что scope разрешен клиенту. Без явного списка клиенту доступны
+// все scope, кроме restricted
+func clientAllowsScope(client *models.Client, scope models.Scope) bool {
+	if len(client.AllowedScopes) == 0 {
+		return !scope.Restricted
+	}
+	return slices.Contains(client.AllowedScopes, scope.Name)
+}
+
+// scopeString список scope через пробел
+func scopeString(scopes []models.Scope) string {
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		names = append(names, scope.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// refreshScopeAllowed при обновлении токена scope можно только сузить (RFC 6749 §6)
+func refreshScopeAllowed(tgr *oauth2.TokenGenerateRequest, oldScope string) (bool, error) {
+	granted := strings.Fields(oldScope)
+	for _, name := range strings.Fields(tgr.Scope) {
+		if !slices.Contains(granted, name) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// supportedScopes scope, публикуемые в метаданных сервера. Restricted scope не публикуются
+func (h *Handler) supportedScopes(ctx context.Context) []string {
+	scopes, err := h.store.ListScopes(ctx)
+	if err != nil {
+		h.logger.Error("Failed to list scopes", "error", err)
+		return nil
+	}
+
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope.Name == scopeOpenID && !h.openIDAvailable() {
+			continue
+		}
+		if !scope.Restricted {
+			names = append(names, scope.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/models"
+)
+
+const (
+	// sessionCookieName cookie с идентификатором сессии и его HMAC подписью
+	sessionCookieName = "oauth2_session"
+	// csrfCookieName cookie с CSRF токеном формы входа (double submit)
+	csrfCookieName = "oauth2_csrf"
+)
+
+// randomToken генерирует случайный токен из n байт в base64url
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// signValue добавляет к значению HMAC-SHA256 подпись секретом сессий
+func (h *Handler) signValue(value string) string {
+	mac := hmac.New(sha256.New, []byte(h.config.SessionSecret))
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedValue проверяет подпись и возвращает исходное значение
+func (h *Handler) verifySignedValue(signed string) (string, bool) {
+	value, _, found := strings.Cut(signed, ".")
+	if !found || value == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signed), []byte(h.signValue(value))) {
+		return "", false
+	}
+	return value, true
+}
+
+// currentSession возвращает действующую сессию из cookie запроса
+func (h *Handler) currentSession(r *http.Request) *models.Session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	sessionID, ok := h.verifySignedValue(cookie.Value)
+	if !ok {
+		return nil
+	}
+
+	session, err := h.store.GetSession(r.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to load session", "error", err)
+		return nil
+	}
+	return session
+}
+
+// startSession создает сессию для вошедшего пользователя и выставляет cookie. amr — методы,
+// которыми пользователь подтвердил вход
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID string, amr []string) (*models.Session, error) {
+	sessionID, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:        sessionID,
+		UserID:    userID,
+		AuthTime:  now,
+		ExpiresAt: now.Add(h.config.SessionLifetime),
+		IPAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: now,
+		AMR:       amr,
+	}
+	if err := h.store.CreateSession(r.Context(), session); err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    h.signValue(session.ID),
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		MaxAge:   int(h.config.SessionLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   h.config.SessionCookieSecure,
+		// Lax передает cookie при переходе на /authorize по ссылке или редиректу со стороннего сайта
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return session, nil
+}
+
+// csrfToken возвращает CSRF токен формы, выставляя cookie при его отсутствии
+func (h *Handler) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil {
+		if token, ok := h.verifySignedValue(cookie.Value); ok {
+			return token, nil
+		}
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    h.signValue(token),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.config.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// validCSRFToken сравнивает токен из формы или из заголовка X-CSRF-Token (запросы JSON
+// страниц входа) с токеном из cookie
+func (h *Handler) validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return false
+	}
+	token, ok := h.verifySignedValue(cookie.Value)
+	if !ok {
+		return false
+	}
+
+	submitted := r.Header.Get("X-CSRF-Token")
+	if submitted == "" {
+		submitted = r.PostFormValue("csrf_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) == 1
+}
+
+// clientIP адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
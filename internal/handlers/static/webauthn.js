@@ -0,0 +1,161 @@
+// Вход и регистрация ключей доступа (WebAuthn). Страницы подключают скрипт без встроенного кода,
+// параметры передаются data-атрибутами кнопок. Без поддержки WebAuthn в браузере блок кнопки остается скрытым
+(function () {
+    'use strict';
+
+    // toBuffer декодирует base64url из параметров сервера
+    function toBuffer(value) {
+        var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        while (base64.length % 4) {
+            base64 += '=';
+        }
+        var binary = atob(base64);
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes.buffer;
+    }
+
+    // toBase64URL кодирует ответ аутентификатора для сервера
+    function toBase64URL(buffer) {
+        var bytes = new Uint8Array(buffer);
+        var binary = '';
+        for (var i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function post(url, button, body) {
+        return fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': button.dataset.csrfToken || ''
+            },
+            body: JSON.stringify(body || {})
+        }).then(function (response) {
+            return response.json().catch(function () {
+                return {};
+            }).then(function (data) {
+                if (!response.ok) {
+                    throw new Error(data.error_description || response.statusText);
+                }
+                return data;
+            });
+        });
+    }
+
+    function decodeDescriptors(descriptors) {
+        (descriptors || []).forEach(function (descriptor) {
+            descriptor.id = toBuffer(descriptor.id);
+        });
+    }
+
+    function getAssertion(options) {
+        var publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        decodeDescriptors(publicKey.allowCredentials);
+
+        return navigator.credentials.get({publicKey: publicKey}).then(function (credential) {
+            var response = {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                authenticatorData: toBase64URL(credential.response.authenticatorData),
+                signature: toBase64URL(credential.response.signature)
+            };
+            if (credential.response.userHandle) {
+                response.userHandle = toBase64URL(credential.response.userHandle);
+            }
+            return {id: credential.id, rawId: toBase64URL(credential.rawId), type: credential.type, response: response};
+        });
+    }
+
+    function createCredential(options) {
+        var publicKey = options.publicKey;
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        publicKey.user.id = toBuffer(publicKey.user.id);
+        decodeDescriptors(publicKey.excludeCredentials);
+
+        return navigator.credentials.create({publicKey: publicKey}).then(function (credential) {
+            return {
+                id: credential.id,
+                rawId: toBase64URL(credential.rawId),
+                type: credential.type,
+                response: {
+                    clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                    attestationObject: toBase64URL(credential.response.attestationObject),
+                    transports: credential.response.getTransports ? credential.response.getTransports() : []
+                }
+            };
+        });
+    }
+
+    // authenticate проходит церемонию входа по адресам path/begin и path/finish
+    function authenticate(button, path) {
+        return post(path + '/begin', button).then(function (begin) {
+            return getAssertion(begin.options).then(function (credential) {
+                return post(path + '/finish', button, {
+                    challenge_id: begin.challenge_id,
+                    credential: credential,
+                    return_to: button.dataset.returnTo || ''
+                });
+            });
+        }).then(function (result) {
+            window.location.assign(result.redirect);
+        });
+    }
+
+    function register(button) {
+        var name = document.getElementById('passkey-name');
+        return post('/passkeys/register/begin', button).then(function (begin) {
+            return createCredential(begin.options).then(function (credential) {
+                return post('/passkeys/register/finish', button, {
+                    challenge_id: begin.challenge_id,
+                    credential: credential,
+                    name: name ? name.value : ''
+                });
+            });
+        }).then(function () {
+            showStatus(button.dataset.success, false);
+        });
+    }
+
+    function showStatus(message, isError) {
+        var status = document.getElementById('passkey-status');
+        if (!status) {
+            return;
+        }
+        status.textContent = message;
+        status.className = isError ? 'error' : 'notice';
+        status.hidden = false;
+    }
+
+    function bind(id, action) {
+        var button = document.getElementById(id);
+        if (!button || !window.PublicKeyCredential) {
+            return;
+        }
+        button.parentElement.hidden = false;
+        button.addEventListener('click', function () {
+            button.disabled = true;
+            action(button).catch(function (err) {
+                console.error(err);
+                showStatus(button.dataset.error, true);
+            }).then(function () {
+                button.disabled = false;
+            });
+        });
+    }
+
+    document.addEventListener('DOMContentLoaded', function () {
+        bind('passkey-login', function (button) {
+            return authenticate(button, '/login/passkey');
+        });
+        bind('passkey-mfa', function (button) {
+            return authenticate(button, '/login/mfa/passkey');
+        });
+        bind('passkey-register', register);
+    });
+})();
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+
+	jwtLib "github.com/golang-jwt/jwt/v5"
+)
+
+// UserInfo godoc
+// @Summary Информация о пользователе
+// @Description Claims пользователя по access токену с учетом выданных scope (OpenID Connect Core §5.3)
+// @Tags openid
+// @Produce json
+// @Produce application/jwt
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string "Токен отсутствует или недействителен"
+// @Failure 403 {object} map[string]string "Токен выдан без scope openid"
+// @Router /userinfo [get]
+// @Router /userinfo [post]
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.writeErrorResponse(w, "invalid_request", "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info, ok := h.authorizeBearer(w, r, scopeOpenID)
+	if !ok {
+		return
+	}
+	if info.Sub == "" {
+		h.writeBearerError(w, "invalid_token", "The access token is invalid or expired", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.store.GetUserByID(ctx, info.Sub)
+	if err != nil {
+		h.logger.Warn("UserInfo subject not found", "sub", info.Sub, "error", err)
+		h.writeBearerError(w, "invalid_token", "The access token subject no longer exists", http.StatusUnauthorized)
+		return
+	}
+
+	claims := userClaims(user, info.Scope)
+	claims["sub"] = user.ID
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	client, err := h.store.GetClient(ctx, info.ClientID)
+	if err != nil || client.UserinfoSignedResponseAlg == "" {
+		h.writeJSONResponse(w, claims, http.StatusOK)
+		return
+	}
+
+	// Клиент зарегистрирован для подписанного ответа (OpenID Connect Core §5.3.2)
+	key := h.keys.SigningKey()
+	if key.Method.Alg() != client.UserinfoSignedResponseAlg {
+		h.logger.Error("UserInfo signing algorithm is not available",
+			"client_id", client.ID,
+			"alg", client.UserinfoSignedResponseAlg,
+		)
+		h.writeErrorResponse(w, "server_error", "UserInfo signing algorithm is not available", http.StatusInternalServerError)
+		return
+	}
+
+	signedClaims := jwtLib.MapClaims{"iss": h.config.Issuer, "aud": client.ID}
+	for name, value := range claims {
+		signedClaims[name] = value
+	}
+
+	signed, err := key.Sign(signedClaims)
+	if err != nil {
+		h.logger.Error("Failed to sign UserInfo response", "client_id", client.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to sign UserInfo response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwt")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(signed))
+}
+
+// bearerToken извлекает access токен из заголовка Authorization или из тела POST запроса (RFC 6750 §2)
+func bearerToken(r *http.Request) (string, bool) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, found := strings.Cut(auth, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", false
+		}
+		return token, true
+	}
+
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if token := r.PostFormValue("access_token"); token != "" {
+			return token, true
+		}
+	}
+	return "", false
+}
+
+// authorizeBearer проверяет access токен запроса к ресурсу и наличие в нем scope. Токен проверяется
+// так же, как при интроспекции: подпись, срок действия и denylist. При ошибке ответ уже отправлен
+func (h *Handler) authorizeBearer(w http.ResponseWriter, r *http.Request, scope string) (models.IntrospectResponse, bool) {
+	token, ok := bearerToken(r)
+	if !ok {
+		// Запрос без токена получает только схему аутентификации (RFC 6750 §3.1)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth2"`)
+		h.writeErrorResponse(w, "invalid_request", "Bearer token is required", http.StatusUnauthorized)
+		return models.IntrospectResponse{}, false
+	}
+
+	info := h.introspectAccessToken(r.Context(), token)
+	if !info.Active {
+		h.writeBearerError(w, "invalid_token", "The access token is invalid or expired", http.StatusUnauthorized)
+		return info, false
+	}
+
+	if !hasScope(info.Scope, scope) {
+		h.writeInsufficientScope(w, scope)
+		return info, false
+	}
+	return info, true
+}
+
+// writeBearerError отправляет ошибку ресурсного сервера с заголовком WWW-Authenticate (RFC 6750 §3)
+func (h *Handler) writeBearerError(w http.ResponseWriter, errorCode, description string, statusCode int) {
+	challenge := fmt.Sprintf(`Bearer realm="oauth2", error=%q, error_description=%q`, errorCode, description)
+	w.Header().Set("WWW-Authenticate", challenge)
+	h.writeErrorResponse(w, errorCode, description, statusCode)
+}
+
+// writeInsufficientScope отправляет insufficient_scope с указанием требуемого scope (RFC 6750 §3.1)
+func (h *Handler) writeInsufficientScope(w http.ResponseWriter, scope string) {
+	description := "The access token was not granted the " + scope + " scope"
+	challenge := fmt.Sprintf(`Bearer realm="oauth2", error="insufficient_scope", error_description=%q, scope=%q`, description, scope)
+	w.Header().Set("WWW-Authenticate", challenge)
+	h.writeErrorResponse(w, "insufficient_scope", description, http.StatusForbidden)
+}
@@ -0,0 +1,666 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Маршруты ключей доступа (WebAuthn)
+const (
+	// PathPasskeys ключи текущего пользователя
+	PathPasskeys = "/passkeys"
+	// PathLoginPasskey вход по ключу вместо пароля
+	PathLoginPasskey = "/login/passkey"
+	// PathLoginMFAPasskey ключ как второй фактор после пароля. Путь вложен в PathLoginMFA,
+	// чтобы браузер передавал cookie незавершенного входа
+	PathLoginMFAPasskey = PathLoginMFA + "/passkey"
+	// PathWebAuthnScript скрипт церемоний WebAuthn для страниц входа
+	PathWebAuthnScript = "/webauthn.js"
+)
+
+// amrHardwareKey вход подтвержден ключом аутентификатора (RFC 8176 §2)
+const amrHardwareKey = "hwk"
+
+// Церемонии WebAuthn, состояние которых хранится между begin и finish
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "mfa"
+)
+
+const (
+	// webAuthnChallengeLifetime время на ответ аутентификатора
+	webAuthnChallengeLifetime = 5 * time.Minute
+	// passkeyNameMaxLength длина названия ключа, совпадает с размером колонки
+	passkeyNameMaxLength = 100
+)
+
+var (
+	// errChallengeNotFound церемония не начата, уже завершена или истекла
+	errChallengeNotFound = errors.New("WebAuthn challenge not found")
+	// errPasskeyCloned счетчик подписей не увеличился: ключ мог быть скопирован
+	errPasskeyCloned = errors.New("passkey sign count did not increase")
+)
+
+//go:embed static/webauthn.js
+var webAuthnScript []byte
+
+// passkeyUser пользователь и его ключи в виде, который ожидает библиотека WebAuthn.
+// Идентификатор пользователя для аутентификатора (user handle) — его UUID
+type passkeyUser struct {
+	user     *models.User
+	passkeys []*models.Passkey
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(u.user.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i, passkey := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+		for j, transport := range passkey.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.ID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.AAGUID,
+				SignCount: passkey.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// loadPasskeyUser загружает пользователя вместе с ключами
+func (h *Handler) loadPasskeyUser(ctx context.Context, userID string) (*passkeyUser, error) {
+	user, err := h.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := h.store.ListPasskeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// saveChallenge сохраняет состояние начатой церемонии и возвращает ее идентификатор для клиента
+func (h *Handler) saveChallenge(ctx context.Context, ceremony, userID string, session *webauthn.SessionData) (string, error) {
+	id, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := &models.WebAuthnChallenge{
+		ID:          id,
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: data,
+		ExpiresAt:   time.Now().Add(webAuthnChallengeLifetime),
+	}
+	if err := h.store.SaveWebAuthnChallenge(ctx, challenge); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// takeChallenge завершает церемонию: состояние удаляется, поэтому ответ на challenge принимается один раз.
+// Церемония, начатая другим пользователем, не принимается
+func (h *Handler) takeChallenge(ctx context.Context, id, ceremony, userID string) (*webauthn.SessionData, error) {
+	if id == "" {
+		return nil, errChallengeNotFound
+	}
+	challenge, err := h.store.TakeWebAuthnChallenge(ctx, id, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil || challenge.UserID != userID {
+		return nil, errChallengeNotFound
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.SessionData, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// usePasskey сохраняет счетчик подписей ключа после проверки ответа. Ответ, счетчик которого
+// не больше сохраненного, отклоняется как возможный клон ключа
+func (h *Handler) usePasskey(ctx context.Context, userID string, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		h.logger.Warn("Passkey sign count did not increase",
+			"event", "passkey_clone_warning",
+			"user_id", userID,
+			"credential_id", base64.RawURLEncoding.EncodeToString(credential.ID),
+		)
+		return errPasskeyCloned
+	}
+
+	updated, err := h.store.UsePasskey(ctx, credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errPasskeyCloned
+	}
+	return nil
+}
+
+// passkeyLoggedIn создает сессию после входа по ключу и сообщает странице входа, куда перейти
+func (h *Handler) passkeyLoggedIn(w http.ResponseWriter, r *http.Request, user *models.User, amr []string, returnTo string) {
+	if _, err := h.startSession(w, r, user.ID, amr); err != nil {
+		h.logger.Error("Failed to start session", "user_id", user.ID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("User logged in", "user_id", user.ID, "username", user.Username, "amr", amr)
+
+	if returnTo == "" {
+		returnTo = PathLogin
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.LoginRedirect{Redirect: returnTo}, http.StatusOK)
+}
+
+// decodeWebAuthnResponse читает ответ аутентификатора. Запросы завершения входа проверяют CSRF токен
+// из заголовка X-CSRF-Token, чтобы сторонний сайт не мог выполнить вход в свою учетную запись
+func (h *Handler) decodeWebAuthnResponse(w http.ResponseWriter, r *http.Request, checkCSRF bool) (*models.WebAuthnResponse, bool) {
+	if checkCSRF && !h.validCSRFToken(r) {
+		h.logger.Warn("WebAuthn CSRF token mismatch", "ip", clientIP(r))
+		h.writeErrorResponse(w, "invalid_request", "Invalid CSRF token", http.StatusForbidden)
+		return nil, false
+	}
+
+	var req models.WebAuthnResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Credential) == 0 {
+		h.writeErrorResponse(w, "invalid_request", "Invalid request format", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// WebAuthnScript godoc
+// @Summary Скрипт ключей доступа
+// @Description JavaScript церемоний WebAuthn, который подключают страницы входа
+// @Tags authorize
+// @Produce application/javascript
+// @Success 200 {string} string "Скрипт"
+// @Router /webauthn.js [get]
+func (h *Handler) WebAuthnScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(webAuthnScript)
+}
+
+// ListPasskeys godoc
+// @Summary Ключи доступа пользователя
+// @Description Зарегистрированные ключи WebAuthn текущего пользователя. Требуется сессия страницы входа
+// @Tags passkeys
+// @Produce json
+// @Success 200 {array} models.PasskeyInfo
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Router /passkeys [get]
+func (h *Handler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	passkeys, err := h.store.ListPasskeys(r.Context(), session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to list passkeys", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to list passkeys", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.PasskeyInfo, len(passkeys))
+	for i, passkey := range passkeys {
+		response[i] = models.PasskeyInfo{
+			ID:         base64.RawURLEncoding.EncodeToString(passkey.ID),
+			Name:       passkey.Name,
+			Synced:     passkey.BackupState,
+			CreatedAt:  passkey.CreatedAt,
+			LastUsedAt: passkey.LastUsedAt,
+		}
+	}
+	h.writeJSONResponse(w, response, http.StatusOK)
+}
+
+// BeginPasskeyRegistration godoc
+// @Summary Начало регистрации ключа доступа
+// @Description Возвращает параметры для navigator.credentials.create(). Аттестация не запрашивается (attestation "none"),
+// @Description ключ должен быть обнаруживаемым, чтобы входить без имени пользователя. Требуется сессия страницы входа
+// @Tags passkeys
+// @Produce json
+// @Success 200 {object} models.WebAuthnOptions
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Router /passkeys/register/begin [post]
+func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.loadPasskeyUser(ctx, session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to load passkey user", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	// Уже зарегистрированные ключи исключаются, чтобы аутентификатор не создал второй ключ для той же учетной записи
+	options, sessionData, err := h.webauthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		h.logger.Error("Failed to begin passkey registration", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	challengeID, err := h.saveChallenge(ctx, ceremonyRegistration, session.UserID, sessionData)
+	if err != nil {
+		h.logger.Error("Failed to save WebAuthn challenge", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.WebAuthnOptions{ChallengeID: challengeID, Options: options}, http.StatusOK)
+}
+
+// FinishPasskeyRegistration godoc
+// @Summary Завершение регистрации ключа доступа
+// @Description Проверяет ответ navigator.credentials.create() и сохраняет ключ
+// @Tags passkeys
+// @Accept json
+// @Produce json
+// @Param request body models.WebAuthnResponse true "challenge_id, ответ аутентификатора и название ключа"
+// @Success 201 {object} models.PasskeyInfo
+// @Failure 400 {object} map[string]string "Неверный ответ аутентификатора или истек срок церемонии"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Router /passkeys/register/finish [post]
+func (h *Handler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	req, ok := h.decodeWebAuthnResponse(w, r, false)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len([]rune(name)) > passkeyNameMaxLength {
+		h.writeErrorResponse(w, "invalid_request", "Passkey name is too long", http.StatusBadRequest)
+		return
+	}
+
+	sessionData, err := h.takeChallenge(ctx, req.ChallengeID, ceremonyRegistration, session.UserID)
+	if errors.Is(err, errChallengeNotFound) {
+		h.writeErrorResponse(w, "invalid_request", "Passkey registration expired, try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load WebAuthn challenge", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to register passkey", http.StatusInternalServerError)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid authenticator response", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.loadPasskeyUser(ctx, session.UserID)
+	if err != nil {
+		h.logger.Error("Failed to load passkey user", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to register passkey", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.webauthn.CreateCredential(user, *sessionData, parsed)
+	if err != nil {
+		h.logger.Warn("Passkey registration failed", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "invalid_request", "Invalid authenticator response", http.StatusBadRequest)
+		return
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+	passkey := &models.Passkey{
+		ID:              credential.ID,
+		UserID:          session.UserID,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	if err := h.store.CreatePasskey(ctx, passkey); err != nil {
+		h.logger.Error("Failed to save passkey", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to register passkey", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Passkey registered", "event", "passkey_registered", "user_id", session.UserID, "name", name)
+
+	h.writeJSONResponse(w, models.PasskeyInfo{
+		ID:        base64.RawURLEncoding.EncodeToString(passkey.ID),
+		Name:      passkey.Name,
+		Synced:    passkey.BackupState,
+		CreatedAt: passkey.CreatedAt,
+	}, http.StatusCreated)
+}
+
+// DeletePasskey godoc
+// @Summary Удаление ключа доступа
+// @Description Удаляет ключ текущего пользователя. Требуется сессия страницы входа
+// @Tags passkeys
+// @Param id path string true "Идентификатор ключа в base64url"
+// @Success 204 "Ключ удален"
+// @Failure 401 {object} map[string]string "Пользователь не вошел"
+// @Failure 404 {object} map[string]string "Ключ не найден"
+// @Router /passkeys/{id} [delete]
+func (h *Handler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	session := h.currentSession(r)
+	if session == nil {
+		h.writeErrorResponse(w, "login_required", "User authentication is required", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "id"))
+	if err != nil {
+		h.writeErrorResponse(w, "not_found", "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := h.store.DeletePasskey(r.Context(), session.UserID, id)
+	if err != nil {
+		h.logger.Error("Failed to delete passkey", "user_id", session.UserID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		h.writeErrorResponse(w, "not_found", "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info("Passkey deleted", "event", "passkey_deleted", "user_id", session.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginPasskeyLogin godoc
+// @Summary Начало входа по ключу доступа
+// @Description Возвращает параметры для navigator.credentials.get() без списка ключей: аутентификатор предлагает
+// @Description обнаруживаемые ключи этого сайта, пользователь определяется по выбранному ключу
+// @Tags authorize
+// @Produce json
+// @Success 200 {object} models.WebAuthnOptions
+// @Router /login/passkey/begin [post]
+func (h *Handler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	// Ключ заменяет пароль, поэтому требуется проверка пользователя аутентификатором (PIN или биометрия)
+	options, sessionData, err := h.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		h.logger.Error("Failed to begin passkey login", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	challengeID, err := h.saveChallenge(r.Context(), ceremonyLogin, "", sessionData)
+	if err != nil {
+		h.logger.Error("Failed to save WebAuthn challenge", "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.WebAuthnOptions{ChallengeID: challengeID, Options: options}, http.StatusOK)
+}
+
+// FinishPasskeyLogin godoc
+// @Summary Завершение входа по ключу доступа
+// @Description Проверяет подпись аутентификатора и счетчик подписей, создает сессию и возвращает адрес для перехода.
+// @Description Требуется CSRF токен страницы входа в заголовке X-CSRF-Token
+// @Tags authorize
+// @Accept json
+// @Produce json
+// @Param X-CSRF-Token header string true "CSRF токен страницы входа"
+// @Param request body models.WebAuthnResponse true "challenge_id, ответ аутентификатора и return_to"
+// @Success 200 {object} models.LoginRedirect
+// @Failure 400 {object} map[string]string "Истек срок церемонии"
+// @Failure 401 {object} map[string]string "Неверный ответ аутентификатора"
+// @Failure 403 {object} map[string]string "Неверный CSRF токен"
+// @Router /login/passkey/finish [post]
+func (h *Handler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := h.decodeWebAuthnResponse(w, r, true)
+	if !ok {
+		return
+	}
+
+	sessionData, err := h.takeChallenge(ctx, req.ChallengeID, ceremonyLogin, "")
+	if errors.Is(err, errChallengeNotFound) {
+		h.writeErrorResponse(w, "invalid_request", "Passkey login expired, try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load WebAuthn challenge", "error", err)
+		h.writeErrorResponse(w, "server_error", "Passkey login failed", http.StatusInternalServerError)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		h.writeErrorResponse(w, "invalid_request", "Invalid authenticator response", http.StatusBadRequest)
+		return
+	}
+
+	// Пользователь определяется по user handle из ответа, библиотека проверяет, что ключ принадлежит ему
+	var user *passkeyUser
+	_, credential, err := h.webauthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		loaded, err := h.loadPasskeyUser(ctx, string(userHandle))
+		user = loaded
+		return loaded, err
+	}, *sessionData, parsed)
+	if err == nil {
+		err = h.usePasskey(ctx, user.user.ID, credential)
+	}
+	if err != nil {
+		h.logger.Warn("Passkey login failed", "ip", clientIP(r), "error", err)
+		h.writeErrorResponse(w, "access_denied", "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if h.config.RequireVerifiedEmail && !user.user.EmailVerified {
+		h.writeErrorResponse(w, "access_denied", "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	h.passkeyLoggedIn(w, r, user.user, []string{amrHardwareKey}, safeReturnTo(req.ReturnTo))
+}
+
+// BeginPasskeySecondFactor godoc
+// @Summary Начало подтверждения входа ключом доступа
+// @Description Возвращает параметры для navigator.credentials.get() с ключами пользователя, прошедшего проверку пароля на /login
+// @Tags authorize
+// @Produce json
+// @Success 200 {object} models.WebAuthnOptions
+// @Failure 401 {object} map[string]string "Истек срок подтверждения входа"
+// @Failure 404 {object} map[string]string "У пользователя нет ключей"
+// @Router /login/mfa/passkey/begin [post]
+func (h *Handler) BeginPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := h.mfaChallengeUser(r)
+	if !ok {
+		h.writeErrorResponse(w, "login_required", "Login confirmation expired, log in again", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.loadPasskeyUser(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to load passkey user", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+	if len(user.passkeys) == 0 {
+		h.writeErrorResponse(w, "not_found", "No passkeys registered", http.StatusNotFound)
+		return
+	}
+
+	options, sessionData, err := h.webauthn.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationPreferred))
+	if err != nil {
+		h.logger.Error("Failed to begin passkey login", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	challengeID, err := h.saveChallenge(ctx, ceremonySecondFactor, userID, sessionData)
+	if err != nil {
+		h.logger.Error("Failed to save WebAuthn challenge", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.writeJSONResponse(w, models.WebAuthnOptions{ChallengeID: challengeID, Options: options}, http.StatusOK)
+}
+
+// FinishPasskeySecondFactor godoc
+// @Summary Подтверждение входа ключом доступа
+// @Description Проверяет ответ аутентификатора пользователя, прошедшего проверку пароля, создает сессию и возвращает
+// @Description адрес для перехода. Неверные ответы учитываются как неудачные входы. Требуется CSRF токен в заголовке X-CSRF-Token
+// @Tags authorize
+// @Accept json
+// @Produce json
+// @Param X-CSRF-Token header string true "CSRF токен страницы входа"
+// @Param request body models.WebAuthnResponse true "challenge_id, ответ аутентификатора и return_to"
+// @Success 200 {object} models.LoginRedirect
+// @Failure 400 {object} map[string]string "Истек срок церемонии"
+// @Failure 401 {object} map[string]string "Неверный ответ аутентификатора или истек срок подтверждения входа"
+// @Failure 403 {object} map[string]string "Неверный CSRF токен"
+// @Failure 429 {object} map[string]string "Слишком много неудачных попыток"
+// @Router /login/mfa/passkey/finish [post]
+func (h *Handler) FinishPasskeySecondFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := h.decodeWebAuthnResponse(w, r, true)
+	if !ok {
+		return
+	}
+
+	userID, ok := h.mfaChallengeUser(r)
+	if !ok {
+		h.writeErrorResponse(w, "login_required", "Login confirmation expired, log in again", http.StatusUnauthorized)
+		return
+	}
+
+	sessionData, err := h.takeChallenge(ctx, req.ChallengeID, ceremonySecondFactor, userID)
+	if errors.Is(err, errChallengeNotFound) {
+		h.writeErrorResponse(w, "invalid_request", "Passkey login expired, try again", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load WebAuthn challenge", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Passkey login failed", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.loadPasskeyUser(ctx, userID)
+	if err != nil {
+		h.logger.Error("Failed to load passkey user", "user_id", userID, "error", err)
+		h.writeErrorResponse(w, "server_error", "Passkey login failed", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.guardLogin(ctx, user.user.Username, clientIP(r)); err != nil {
+		if errors.Is(err, errLoginLocked) {
+			setLoginRetryAfter(w, err)
+			h.clearMFAChallenge(w)
+			h.writeErrorResponse(w, "access_denied", "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		h.writeErrorResponse(w, "server_error", "Passkey login failed", http.StatusInternalServerError)
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	var credential *webauthn.Credential
+	if err == nil {
+		credential, err = h.webauthn.ValidateLogin(user, *sessionData, parsed)
+	}
+	if err == nil {
+		err = h.usePasskey(ctx, userID, credential)
+	}
+	if err != nil {
+		h.logger.Warn("Second factor failed", "user_id", userID, "ip", clientIP(r), "error", err)
+		h.loginFailed(ctx, user.user.Username, clientIP(r))
+		h.writeErrorResponse(w, "access_denied", "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+
+	h.loginSucceeded(ctx, user.user)
+	h.clearMFAChallenge(w)
+	h.passkeyLoggedIn(w, r, user.user, []string{amrPassword, amrHardwareKey}, safeReturnTo(req.ReturnTo))
+}
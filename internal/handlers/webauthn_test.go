@@ -0,0 +1,551 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"go_oauth2_server/internal/config"
+	"go_oauth2_server/internal/hasher"
+	"go_oauth2_server/internal/models"
+	"go_oauth2_server/internal/storage"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// Флаги данных аутентификатора (WebAuthn §6.1)
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softAuthenticator программный аутентификатор с ключом ES256 и аттестацией none
+type softAuthenticator struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatalf("generate credential id: %v", err)
+	}
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+// create отвечает на navigator.credentials.create() с параметрами, полученными страницей от сервера
+func (a *softAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	a.t.Helper()
+
+	userID, _ := options.Response.User.ID.(string)
+	userHandle, err := base64.RawURLEncoding.DecodeString(userID)
+	if err != nil {
+		a.t.Fatalf("decode user handle: %v", err)
+	}
+	a.userHandle = userHandle
+	clientData := a.clientData(protocol.CreateCeremony, options.Response.Challenge)
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("marshal public key: %v", err)
+	}
+
+	authData := a.authData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		a.t.Fatalf("marshal attestation object: %v", err)
+	}
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    encode(clientData),
+		"attestationObject": encode(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+// get отвечает на navigator.credentials.get(), подписывая текущим значением счетчика
+func (a *softAuthenticator) get(options *protocol.CredentialAssertion) []byte {
+	a.t.Helper()
+
+	clientData := a.clientData(protocol.AssertCeremony, options.Response.Challenge)
+	authData := a.authData(flagUserPresent | flagUserVerified)
+
+	digest := sha256.Sum256(clientData)
+	signed := sha256.Sum256(append(append([]byte{}, authData...), digest[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		a.t.Fatalf("sign assertion: %v", err)
+	}
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": challenge.String(),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		a.t.Fatalf("marshal client data: %v", err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) response(response map[string]interface{}) []byte {
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.id),
+		"rawId":    encode(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatalf("marshal credential: %v", err)
+	}
+	return data
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// newTestHandler подключается к TEST_DATABASE_URL, применяет миграции и возвращает обработчик
+// с проверяющей стороной, настроенной как на сервере. Без переменной тест пропускается
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		t.Fatalf("create migration driver: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://../../migrations", "postgres", driver)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("run migrations: %v", err)
+	}
+
+	params := hasher.DefaultParams()
+	params.BcryptCost = 4
+	secretHasher, err := hasher.New(hasher.Bcrypt, params)
+	if err != nil {
+		t.Fatalf("create hasher: %v", err)
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  testRPID,
+		RPDisplayName:         "OAuth2 Server",
+		RPOrigins:             []string{testOrigin},
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+	if err != nil {
+		t.Fatalf("create WebAuthn: %v", err)
+	}
+
+	return &Handler{
+		store:  storage.NewPostgresStore(db, secretHasher, secretHasher, storage.NewTokenHasher([]byte("test-token-hash-secret"))),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		config: &config.Config{
+			SessionSecret:   "test-session-secret",
+			SessionLifetime: time.Hour,
+		},
+		webauthn: wa,
+	}
+}
+
+// newTestUser создает пользователя, удаляемого вместе с ключами и сессиями после теста
+func newTestUser(t *testing.T, h *Handler) *models.User {
+	t.Helper()
+
+	user := &models.User{
+		ID:        uuid.NewString(),
+		Username:  "passkey-" + uuid.NewString(),
+		Password:  "password",
+		CreatedAt: time.Now(),
+	}
+	if err := h.store.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { h.store.DeleteUser(context.Background(), user.ID) })
+	return user
+}
+
+// sessionCookie входит пользователем по паролю и возвращает cookie сессии страницы входа
+func sessionCookie(t *testing.T, h *Handler, user *models.User) *http.Cookie {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if _, err := h.startSession(rec, httptest.NewRequest(http.MethodPost, PathLogin, nil), user.ID, []string{amrPassword}); err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	return responseCookie(t, rec, sessionCookieName)
+}
+
+// csrfCookie открывает страницу входа и возвращает cookie с CSRF токеном и сам токен
+func csrfCookie(t *testing.T, h *Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	token, err := h.csrfToken(rec, httptest.NewRequest(http.MethodGet, PathLogin, nil))
+	if err != nil {
+		t.Fatalf("create CSRF token: %v", err)
+	}
+	return responseCookie(t, rec, csrfCookieName), token
+}
+
+func responseCookie(t *testing.T, rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	t.Fatalf("response has no %s cookie", name)
+	return nil
+}
+
+// post вызывает обработчик JSON запросом с cookie и заголовками страницы входа
+func post(handler http.HandlerFunc, body interface{}, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decodeOptions разбирает ответ begin-обработчика в challenge_id и параметры для браузера
+func decodeOptions(t *testing.T, rec *httptest.ResponseRecorder, options interface{}) string {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("begin status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	response := struct {
+		ChallengeID string          `json:"challenge_id"`
+		Options     json.RawMessage `json:"options"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode begin response: %v", err)
+	}
+	if err := json.Unmarshal(response.Options, options); err != nil {
+		t.Fatalf("decode options: %v", err)
+	}
+	return response.ChallengeID
+}
+
+// registerPasskey регистрирует ключ аутентификатора через BeginPasskeyRegistration и FinishPasskeyRegistration
+func registerPasskey(t *testing.T, h *Handler, session *http.Cookie, authenticator *softAuthenticator) {
+	t.Helper()
+
+	var options protocol.CredentialCreation
+	challengeID := decodeOptions(t, post(h.BeginPasskeyRegistration, nil, nil, session), &options)
+
+	rec := post(h.FinishPasskeyRegistration, models.WebAuthnResponse{
+		ChallengeID: challengeID,
+		Credential:  authenticator.create(&options),
+		Name:        "Test key",
+	}, nil, session)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("finish registration status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+}
+
+// beginLogin начинает вход по ключу и возвращает ответ аутентификатора на challenge
+func beginLogin(t *testing.T, h *Handler, authenticator *softAuthenticator) models.WebAuthnResponse {
+	t.Helper()
+
+	var options protocol.CredentialAssertion
+	challengeID := decodeOptions(t, post(h.BeginPasskeyLogin, nil, nil), &options)
+	return models.WebAuthnResponse{ChallengeID: challengeID, Credential: authenticator.get(&options)}
+}
+
+// finishLogin завершает вход по ключу с CSRF токеном страницы входа
+func finishLogin(t *testing.T, h *Handler, req models.WebAuthnResponse) *httptest.ResponseRecorder {
+	t.Helper()
+
+	cookie, token := csrfCookie(t, h)
+	return post(h.FinishPasskeyLogin, req, http.Header{"X-Csrf-Token": {token}}, cookie)
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	user := newTestUser(t, h)
+	session := sessionCookie(t, h, user)
+	authenticator := newSoftAuthenticator(t)
+
+	var options protocol.CredentialCreation
+	challengeID := decodeOptions(t, post(h.BeginPasskeyRegistration, nil, nil, session), &options)
+	finish := models.WebAuthnResponse{
+		ChallengeID: challengeID,
+		Credential:  authenticator.create(&options),
+		Name:        "Test key",
+	}
+
+	rec := post(h.FinishPasskeyRegistration, finish, nil, session)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("finish status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if string(authenticator.userHandle) != user.ID {
+		t.Errorf("user handle = %q, want user id %q", authenticator.userHandle, user.ID)
+	}
+
+	passkeys, err := h.store.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("list passkeys: %v", err)
+	}
+	if len(passkeys) != 1 || !bytes.Equal(passkeys[0].ID, authenticator.id) {
+		t.Fatalf("stored passkeys = %v, want credential %x", passkeys, authenticator.id)
+	}
+	if passkeys[0].AttestationType != "none" || passkeys[0].Name != "Test key" {
+		t.Errorf("stored passkey = %+v, want attestation none and name from request", passkeys[0])
+	}
+
+	// Ответ на завершенную церемонию не принимается второй раз
+	if rec := post(h.FinishPasskeyRegistration, finish, nil, session); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed challenge status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Повторная регистрация того же аутентификатора исключается списком ключей пользователя
+	decodeOptions(t, post(h.BeginPasskeyRegistration, nil, nil, session), &options)
+	exclude := options.Response.CredentialExcludeList
+	if len(exclude) != 1 || !bytes.Equal(exclude[0].CredentialID, authenticator.id) {
+		t.Errorf("exclude list = %v, want registered credential", exclude)
+	}
+}
+
+func TestPasskeyRegistrationRequiresSession(t *testing.T) {
+	h := newTestHandler(t)
+
+	if rec := post(h.BeginPasskeyRegistration, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("begin status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPasskeyRegistrationRejectsOtherUsersChallenge(t *testing.T) {
+	h := newTestHandler(t)
+	alice := newTestUser(t, h)
+	bob := newTestUser(t, h)
+	bobSession := sessionCookie(t, h, bob)
+	authenticator := newSoftAuthenticator(t)
+
+	// Церемония начата Алисой, а завершить ее пытается Боб
+	var options protocol.CredentialCreation
+	challengeID := decodeOptions(t, post(h.BeginPasskeyRegistration, nil, nil, sessionCookie(t, h, alice)), &options)
+
+	rec := post(h.FinishPasskeyRegistration, models.WebAuthnResponse{
+		ChallengeID: challengeID,
+		Credential:  authenticator.create(&options),
+	}, nil, bobSession)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("finish status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	for _, user := range []*models.User{alice, bob} {
+		passkeys, err := h.store.ListPasskeys(context.Background(), user.ID)
+		if err != nil {
+			t.Fatalf("list passkeys: %v", err)
+		}
+		if len(passkeys) != 0 {
+			t.Errorf("user %s has %d passkeys, want none", user.Username, len(passkeys))
+		}
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	user := newTestUser(t, h)
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, h, sessionCookie(t, h, user), authenticator)
+
+	authenticator.signCount = 1
+	finish := beginLogin(t, h, authenticator)
+	finish.ReturnTo = "/authorize?client_id=test"
+
+	rec := finishLogin(t, h, finish)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("finish status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var redirect models.LoginRedirect
+	if err := json.Unmarshal(rec.Body.Bytes(), &redirect); err != nil {
+		t.Fatalf("decode login response: %v", err)
+	}
+	if redirect.Redirect != finish.ReturnTo {
+		t.Errorf("redirect = %q, want %q", redirect.Redirect, finish.ReturnTo)
+	}
+
+	// Вход создал сессию пользователя с методом аутентификации hwk
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(responseCookie(t, rec, sessionCookieName))
+	session := h.currentSession(req)
+	if session == nil || session.UserID != user.ID {
+		t.Fatalf("session = %+v, want session of user %s", session, user.ID)
+	}
+	if !slices.Equal(session.AMR, []string{amrHardwareKey}) {
+		t.Errorf("session amr = %v, want [%s]", session.AMR, amrHardwareKey)
+	}
+
+	stored, err := h.store.GetPasskey(ctx, authenticator.id)
+	if err != nil {
+		t.Fatalf("get passkey: %v", err)
+	}
+	if stored.SignCount != 1 {
+		t.Errorf("stored sign count = %d, want 1", stored.SignCount)
+	}
+
+	// Ответ на завершенную церемонию не принимается второй раз
+	if rec := finishLogin(t, h, finish); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed challenge status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPasskeyLoginRequiresCSRFToken(t *testing.T) {
+	h := newTestHandler(t)
+	user := newTestUser(t, h)
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, h, sessionCookie(t, h, user), authenticator)
+
+	finish := beginLogin(t, h, authenticator)
+	cookie, token := csrfCookie(t, h)
+
+	if rec := post(h.FinishPasskeyLogin, finish, nil, cookie); rec.Code != http.StatusForbidden {
+		t.Errorf("status without X-CSRF-Token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := post(h.FinishPasskeyLogin, finish, http.Header{"X-Csrf-Token": {token + "x"}}, cookie); rec.Code != http.StatusForbidden {
+		t.Errorf("status with wrong X-CSRF-Token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := post(h.FinishPasskeyLogin, finish, http.Header{"X-Csrf-Token": {token}}); rec.Code != http.StatusForbidden {
+		t.Errorf("status without CSRF cookie = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// Отклоненные запросы не израсходовали церемонию
+	if rec := post(h.FinishPasskeyLogin, finish, http.Header{"X-Csrf-Token": {token}}, cookie); rec.Code != http.StatusOK {
+		t.Errorf("status with CSRF token = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	user := newTestUser(t, h)
+	authenticator := newSoftAuthenticator(t)
+	registerPasskey(t, h, sessionCookie(t, h, user), authenticator)
+
+	authenticator.signCount = 10
+	if rec := finishLogin(t, h, beginLogin(t, h, authenticator)); rec.Code != http.StatusOK {
+		t.Fatalf("login status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	// Копия ключа подписывает со счетчиком меньше сохраненного
+	authenticator.signCount = 3
+	rec := finishLogin(t, h, beginLogin(t, h, authenticator))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("cloned key status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			t.Error("cloned key started a session")
+		}
+	}
+
+	stored, err := h.store.GetPasskey(ctx, authenticator.id)
+	if err != nil {
+		t.Fatalf("get passkey: %v", err)
+	}
+	if stored.SignCount != 10 {
+		t.Errorf("stored sign count = %d, want 10", stored.SignCount)
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go_oauth2_server/internal/jwt"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// Пути эндпоинтов. Используются и в роутере, и в метаданных сервера, чтобы они не расходились.
+const (
+	PathAuthorize           = "/authorize"
+	PathToken               = "/token"
+	PathIntrospect          = "/introspect"
+	PathRevoke              = "/revoke"
+	PathClients             = "/clients"
+	PathUserInfo            = "/userinfo"
+	PathJWKS                = "/.well-known/jwks.json"
+	PathOpenIDConfig        = "/.well-known/openid-configuration"
+	PathAuthorizationServer = "/.well-known/oauth-authorization-server"
+)
+
+// Методы аутентификации клиентов (RFC 8414 §2)
+const (
+	authMethodBasic = "client_secret_basic"
+	authMethodPost  = "client_secret_post"
+	authMethodNone  = "none"
+)
+
+// ServerMetadata метаданные сервера авторизации (RFC 8414 §2).
+// Поля OpenID Connect Discovery 1.0 §3 заполняются только для openid-configuration.
+type ServerMetadata struct {
+	Issuer                                    string   `json:"issuer"`
+	AuthorizationEndpoint                     string   `json:"authorization_endpoint"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	JWKSURI                                   string   `json:"jwks_uri"`
+	RegistrationEndpoint                      string   `json:"registration_endpoint"`
+	ScopesSupported                           []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported"`
+	UserInfoEndpoint                          string   `json:"userinfo_endpoint,omitempty"`
+	UserInfoSigningAlgValuesSupported         []string `json:"userinfo_signing_alg_values_supported,omitempty"`
+	SubjectTypesSupported                     []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported          []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	ClaimsSupported                           []string `json:"claims_supported,omitempty"`
+}
+
+// JWKS godoc
+// @Summary Публичные ключи подписи
+// @Description Набор публичных ключей для локальной проверки JWT (RFC 7517)
+// @Tags well-known
+// @Produce json
+// @Success 200 {object} jwt.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeJSONResponse(w, jwt.BuildJWKS(h.keys), http.StatusOK)
+}
+
+// OpenIDConfiguration godoc
+// @Summary Метаданные OpenID Connect
+// @Description Документ OpenID Connect Discovery 1.0 для автоматической настройки клиентов
+// @Tags well-known
+// @Produce json
+// @Success 200 {object} ServerMetadata
+// @Router /.well-known/openid-configuration [get]
+func (h *Handler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeJSONResponse(w, h.serverMetadata(r.Context(), true), http.StatusOK)
+}
+
+// AuthorizationServerMetadata godoc
+// @Summary Метаданные сервера авторизации
+// @Description Метаданные сервера авторизации OAuth 2.0 (RFC 8414)
+// @Tags well-known
+// @Produce json
+// @Success 200 {object} ServerMetadata
+// @Router /.well-known/oauth-authorization-server [get]
+func (h *Handler) AuthorizationServerMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	h.writeJSONResponse(w, h.serverMetadata(r.Context(), false), http.StatusOK)
+}
+
+// serverMetadata собирает метаданные из фактической конфигурации сервера OAuth2, набора ключей и реестра scope
+func (h *Handler) serverMetadata(ctx context.Context, openID bool) ServerMetadata {
+	issuer := strings.TrimSuffix(h.config.Issuer, "/")
+
+	responseTypes := make([]string, 0, len(h.srv.Config.AllowedResponseTypes))
+	for _, rt := range h.srv.Config.AllowedResponseTypes {
+		responseTypes = append(responseTypes, rt.String())
+	}
+
+	grantTypes := make([]string, 0, len(h.srv.Config.AllowedGrantTypes))
+	for _, gt := range h.srv.Config.AllowedGrantTypes {
+		grantTypes = append(grantTypes, gt.String())
+	}
+
+	// plain разрешен библиотекой, но отклоняется в validateAuthorizePKCE при PKCE_ALLOW_PLAIN=false
+	codeChallengeMethods := make([]string, 0, len(h.srv.Config.AllowedCodeChallengeMethods))
+	for _, method := range h.srv.Config.AllowedCodeChallengeMethods {
+		if method == oauth2.CodeChallengePlain && !h.config.PKCEAllowPlain {
+			continue
+		}
+		codeChallengeMethods = append(codeChallengeMethods, method.String())
+	}
+
+	metadata := ServerMetadata{
+		Issuer:                                    issuer,
+		AuthorizationEndpoint:                     issuer + PathAuthorize,
+		TokenEndpoint:                             issuer + PathToken,
+		JWKSURI:                                   issuer + PathJWKS,
+		RegistrationEndpoint:                      issuer + PathClients,
+		ScopesSupported:                           h.supportedScopes(ctx),
+		ResponseTypesSupported:                    responseTypes,
+		GrantTypesSupported:                       grantTypes,
+		TokenEndpointAuthMethodsSupported:         []string{authMethodBasic, authMethodPost, authMethodNone},
+		RevocationEndpoint:                        issuer + PathRevoke,
+		RevocationEndpointAuthMethodsSupported:    []string{authMethodBasic, authMethodPost, authMethodNone},
+		IntrospectionEndpoint:                     issuer + PathIntrospect,
+		IntrospectionEndpointAuthMethodsSupported: []string{authMethodBasic, authMethodPost},
+		CodeChallengeMethodsSupported:             codeChallengeMethods,
+	}
+
+	if openID {
+		metadata.UserInfoEndpoint = issuer + PathUserInfo
+		metadata.UserInfoSigningAlgValuesSupported = h.userInfoSigningAlgorithms()
+		metadata.SubjectTypesSupported = []string{"public"}
+		metadata.IDTokenSigningAlgValuesSupported = h.idTokenSigningAlgorithms()
+		metadata.ClaimsSupported = []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "c_hash",
+			"name", "given_name", "family_name", "nickname", "preferred_username", "picture",
+			"locale", "zoneinfo", "updated_at", "email", "email_verified",
+			"phone_number", "phone_number_verified", "address",
+		}
+	}
+
+	return metadata
+}
+
+// idTokenSigningAlgorithms алгоритмы асимметричных ключей, которыми подписываются и проверяются ID токены
+func (h *Handler) idTokenSigningAlgorithms() []string {
+	var algorithms []string
+	for _, key := range h.keys.VerificationKeys() {
+		if !key.IsAsymmetric() {
+			continue
+		}
+		if alg := key.Method.Alg(); !slices.Contains(algorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// userInfoSigningAlgorithms алгоритмы подписи ответа /userinfo: только активный асимметричный ключ,
+// так как подпись общим секретом HS256 клиент проверить не может
+func (h *Handler) userInfoSigningAlgorithms() []string {
+	if key := h.keys.SigningKey(); key.IsAsymmetric() {
+		return []string{key.Method.Alg()}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package hasher хеширует секреты (пароли, секреты клиентов) алгоритмами bcrypt и argon2id.
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Поддерживаемые алгоритмы
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// Длины ключа и соли argon2id
+const (
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// argon2Prefix префикс хеша argon2id в формате PHC
+const argon2Prefix = "$argon2id$"
+
+// Params параметры стоимости новых хешей
+type Params struct {
+	// Argon2Memory объем памяти argon2id в KiB
+	Argon2Memory uint32
+	// Argon2Time число проходов argon2id
+	Argon2Time uint32
+	// Argon2Threads число потоков argon2id
+	Argon2Threads uint8
+	// BcryptCost стоимость bcrypt
+	BcryptCost int
+}
+
+// DefaultParams параметры по рекомендации OWASP: argon2id с 19 MiB памяти, 2 проходами
+// и 1 потоком, bcrypt со стоимостью 10
+func DefaultParams() Params {
+	return Params{
+		Argon2Memory:  19 * 1024,
+		Argon2Time:    2,
+		Argon2Threads: 1,
+		BcryptCost:    bcrypt.DefaultCost,
+	}
+}
+
+// Hasher хеширует секреты выбранным алгоритмом. Проверяются хеши любого поддерживаемого
+// алгоритма, поэтому смена алгоритма не ломает уже сохраненные хеши.
+type Hasher struct {
+	algorithm string
+	params    Params
+}
+
+// New создает Hasher для алгоритма bcrypt или argon2id с заданными параметрами
+func New(algorithm string, params Params) (*Hasher, error) {
+	switch algorithm {
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		if params.Argon2Time < 1 || params.Argon2Threads < 1 || params.Argon2Memory < 8*uint32(params.Argon2Threads) {
+			return nil, fmt.Errorf("invalid argon2id parameters: memory must be at least 8 KiB per thread, time and threads at least 1")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+	}
+	return &Hasher{algorithm: algorithm, params: params}, nil
+}
+
+// Algorithm алгоритм новых хешей
+func (h *Hasher) Algorithm() string {
+	return h.algorithm
+}
+
+// Hash хеширует секрет
+func (h *Hasher) Hash(secret string) (string, error) {
+	if h.algorithm == Argon2id {
+		return hashArgon2id(secret, h.argon2Params())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), h.params.BcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash secret: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Verify проверяет секрет по хешу за время, не зависящее от совпадающей части
+func (h *Hasher) Verify(hash, secret string) bool {
+	if strings.HasPrefix(hash, argon2Prefix) {
+		return verifyArgon2id(hash, secret)
+	}
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	}
+	return false
+}
+
+// NeedsRehash сообщает, что хеш получен другим алгоритмом или с другими параметрами
+// и его стоит пересчитать при следующей успешной проверке
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if h.algorithm == Argon2id {
+		params, _, key, err := decodeArgon2id(hash)
+		return err != nil || params != h.argon2Params() || len(key) != argon2KeyLen
+	}
+
+	if !isBcrypt(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.params.BcryptCost
+}
+
+// isBcrypt проверяет префикс хеша bcrypt ($2a$, $2b$, $2y$)
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// argon2Params параметры argon2id, записываемые в хеш
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// argon2Params параметры argon2id новых хешей
+func (h *Hasher) argon2Params() argon2Params {
+	return argon2Params{memory: h.params.Argon2Memory, time: h.params.Argon2Time, threads: h.params.Argon2Threads}
+}
+
+// hashArgon2id хеширует секрет argon2id и кодирует результат в формате PHC:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func hashArgon2id(secret string, params argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, params.time, params.memory, params.threads, argon2KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyArgon2id проверяет секрет по хешу argon2id с параметрами из самого хеша
+func verifyArgon2id(hash, secret string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	actual := argon2.IDKey([]byte(secret), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+// decodeArgon2id разбирает хеш argon2id в формате PHC
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+
+	return params, salt, key, nil
+}
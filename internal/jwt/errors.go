@@ -4,4 +4,7 @@ import "errors"
 
 var (
 	ErrInvalidSigningMethod = errors.New("invalid signing method")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrRotationConflict     = errors.New("signing key was rotated concurrently")
 )
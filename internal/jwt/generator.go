@@ -2,47 +2,106 @@ package jwt
 
 import (
 	"context"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Ключи расширений токена. Менеджер OAuth2 копирует расширения authorization code
+// в выдаваемый по нему токен, а хранилище сохраняет их вместе с токеном.
+const (
+	// ExtensionJTI jti выпущенного JWT, нужен для внесения отозванного токена в denylist
+	ExtensionJTI = "jti"
+	// ExtensionAuthorizationCode код, из которого выпущен токен
+	ExtensionAuthorizationCode = "authorization_code"
+	// ExtensionNonce nonce из запроса /authorize (OpenID Connect Core §3.1.2.1)
+	ExtensionNonce = "nonce"
+	// ExtensionAuthTime время аутентификации пользователя в секундах Unix
+	ExtensionAuthTime = "auth_time"
+	// ExtensionFamilyID семейство refresh токенов, выпущенных ротацией из одного гранта
+	ExtensionFamilyID = "family_id"
+	// ExtensionAMR методы аутентификации пользователя через пробел (RFC 8176)
+	ExtensionAMR = "amr"
 )
 
+// refreshTokenBytes длина refresh токена в байтах до кодирования
+const refreshTokenBytes = 32
+
 // JWTAccessGenerate JWT access token generator
 type JWTAccessGenerate struct {
-	SignedKey    []byte
-	SignedMethod jwt.SigningMethod
+	Keys   KeyProvider
+	Issuer string
 }
 
-// NewJWTAccessGenerate создает экземпляр токена доступа jwt
-func NewJWTAccessGenerate(key []byte, method jwt.SigningMethod) *JWTAccessGenerate {
+// NewJWTAccessGenerate создает экземпляр токена доступа jwt, подписываемого активным ключом из набора
+func NewJWTAccessGenerate(keys KeyProvider) *JWTAccessGenerate {
 	return &JWTAccessGenerate{
-		SignedKey:    key,
-		SignedMethod: method,
+		Keys: keys,
 	}
 }
 
 // Token generates JWT access token
 func (a *JWTAccessGenerate) Token(ctx context.Context, data *oauth2.GenerateBasic, isGenRefresh bool) (access, refresh string, err error) {
 
+	jti := uuid.New().String()
 	claims := jwt.MapClaims{
 		"aud": data.Client.GetID(),
 		"sub": data.UserID,
 		"exp": data.TokenInfo.GetAccessCreateAt().Add(data.TokenInfo.GetAccessExpiresIn()).Unix(),
 		"iat": data.TokenInfo.GetAccessCreateAt().Unix(),
+		"nbf": data.TokenInfo.GetAccessCreateAt().Unix(),
+		"jti": jti,
+	}
+	if a.Issuer != "" {
+		claims["iss"] = a.Issuer
+	}
+	if scope := data.TokenInfo.GetScope(); scope != "" {
+		claims["scope"] = scope
+	}
+	// Методы аутентификации позволяют ресурсному серверу требовать второй фактор
+	if eti, ok := data.TokenInfo.(oauth2.ExtendableTokenInfo); ok {
+		if amr := strings.Fields(eti.GetExtension().Get(ExtensionAMR)); len(amr) > 0 {
+			claims["amr"] = amr
+		}
 	}
 
-	token := jwt.NewWithClaims(a.SignedMethod, claims)
-	access, err = token.SignedString(a.SignedKey)
+	access, err = a.Keys.SigningKey().Sign(claims)
 	if err != nil {
 		return "", "", err
 	}
 
+	if eti, ok := data.TokenInfo.(oauth2.ExtendableTokenInfo); ok {
+		ext := eti.GetExtension()
+		if ext == nil {
+			ext = make(url.Values)
+		}
+		ext.Set(ExtensionJTI, jti)
+		eti.SetExtension(ext)
+	}
+
 	refresh = ""
 	if isGenRefresh {
-		t := sha256.Sum256([]byte(access))
-		refresh = base64.URLEncoding.EncodeToString(t[:])
+		refresh, err = randomRefreshToken()
+		if err != nil {
+			return "", "", err
+		}
 	}
 
 	return access, refresh, nil
 }
+
+// randomRefreshToken создает непрозрачный refresh токен из криптографически стойкого генератора.
+// Токен не зависит от access токена и не может быть вычислен по нему
+func randomRefreshToken() (string, error) {
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
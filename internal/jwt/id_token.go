@@ -0,0 +1,34 @@
+package jwt
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenHash вычисляет at_hash или c_hash: левая половина хеша значения в base64url
+// (OpenID Connect Core §3.1.3.6). Хеш-функция соответствует алгоритму подписи ID токена.
+func TokenHash(method jwt.SigningMethod, value string) (string, error) {
+	var hash crypto.Hash
+	switch method.Alg() {
+	case "HS256", "RS256", "ES256", "PS256":
+		hash = crypto.SHA256
+	case "HS384", "RS384", "ES384", "PS384":
+		hash = crypto.SHA384
+	case "HS512", "RS512", "ES512", "PS512", "EdDSA":
+		// Для Ed25519 используется SHA-512
+		hash = crypto.SHA512
+	default:
+		return "", fmt.Errorf("unsupported signing algorithm for token hash: %s", method.Alg())
+	}
+
+	h := hash.New()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
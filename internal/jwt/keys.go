@@ -0,0 +1,315 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey ключ подписи JWT. Для асимметричных алгоритмов содержит приватный ключ,
+// для HS256 — общий секрет, который никогда не публикуется в JWKS.
+type SigningKey struct {
+	KID        string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	Secret     []byte
+}
+
+// NewSigningKey создает ключ подписи по приватному ключу, определяя алгоритм по типу ключа.
+// kid вычисляется как JWK Thumbprint (RFC 7638).
+func NewSigningKey(privateKey crypto.Signer) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve: %s", key.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+
+	key := &SigningKey{Method: method, PrivateKey: privateKey}
+	jwk, err := key.JWK()
+	if err != nil {
+		return nil, err
+	}
+	kid, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.KID = kid
+
+	return key, nil
+}
+
+// NewHMACKey создает ключ подписи HS256 на общем секрете
+func NewHMACKey(secret []byte) *SigningKey {
+	return &SigningKey{Method: jwt.SigningMethodHS256, Secret: secret}
+}
+
+// LoadSigningKey загружает приватный ключ RSA, ECDSA или Ed25519 из PEM файла
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+
+	privateKey, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	return NewSigningKey(privateKey)
+}
+
+// ParsePrivateKeyPEM разбирает приватный ключ в форматах PKCS#8, PKCS#1 и SEC 1
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+// IsAsymmetric сообщает, можно ли опубликовать ключ проверки
+func (k *SigningKey) IsAsymmetric() bool {
+	return k.PrivateKey != nil
+}
+
+// signKey возвращает ключ в виде, который ожидает SigningMethod при подписи
+func (k *SigningKey) signKey() interface{} {
+	if k.IsAsymmetric() {
+		return k.PrivateKey
+	}
+	return k.Secret
+}
+
+// verifyKey возвращает ключ в виде, который ожидает SigningMethod при проверке
+func (k *SigningKey) verifyKey() interface{} {
+	if k.IsAsymmetric() {
+		return k.PrivateKey.Public()
+	}
+	return k.Secret
+}
+
+// Sign подписывает claims ключом и добавляет kid в заголовок
+func (k *SigningKey) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	if k.KID != "" {
+		token.Header["kid"] = k.KID
+	}
+	return token.SignedString(k.signKey())
+}
+
+// JWK публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS набор публичных ключей (RFC 7517 §5)
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK возвращает публичную часть ключа в формате JWK
+func (k *SigningKey) JWK() (JWK, error) {
+	if !k.IsAsymmetric() {
+		return JWK{}, fmt.Errorf("symmetric keys cannot be published")
+	}
+
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.KID}
+	switch pub := k.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdhKey, err := pub.ECDH()
+		if err != nil {
+			return JWK{}, fmt.Errorf("failed to encode ECDSA key: %w", err)
+		}
+		// Несжатая точка: 0x04 || X || Y, координаты фиксированной длины
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(point[1 : 1+size])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(point[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+
+	return jwk, nil
+}
+
+// Thumbprint вычисляет JWK Thumbprint по обязательным полям ключа (RFC 7638 §3)
+func (j JWK) Thumbprint() (string, error) {
+	var members []string
+	switch j.Kty {
+	case "RSA":
+		members = []string{"e", j.E, "kty", j.Kty, "n", j.N}
+	case "EC":
+		members = []string{"crv", j.Crv, "kty", j.Kty, "x", j.X, "y", j.Y}
+	case "OKP":
+		members = []string{"crv", j.Crv, "kty", j.Kty, "x", j.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", j.Kty)
+	}
+
+	// Члены уже упорядочены лексикографически, пробелы не допускаются
+	parts := make([]string, 0, len(members)/2)
+	for i := 0; i < len(members); i += 2 {
+		name, _ := json.Marshal(members[i])
+		value, _ := json.Marshal(members[i+1])
+		parts = append(parts, string(name)+":"+string(value))
+	}
+
+	sum := sha256.Sum256([]byte("{" + strings.Join(parts, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KeyProvider источник ключей для подписи и проверки JWT
+type KeyProvider interface {
+	// SigningKey активный ключ подписи
+	SigningKey() *SigningKey
+	// VerificationKey ключ проверки по kid
+	VerificationKey(kid string) (*SigningKey, bool)
+	// VerificationKeys все ключи, пригодные для проверки
+	VerificationKeys() []*SigningKey
+}
+
+// KeySet неизменяемый набор ключей: активный ключ подписи и ключи, используемые только для проверки
+type KeySet struct {
+	signing *SigningKey
+	keys    []*SigningKey
+}
+
+// NewKeySet создает набор ключей. Первым ключом подписываются токены, остальные только проверяются.
+func NewKeySet(signing *SigningKey, verification ...*SigningKey) *KeySet {
+	return &KeySet{
+		signing: signing,
+		keys:    append([]*SigningKey{signing}, verification...),
+	}
+}
+
+// LoadKeySet загружает ключи из PEM файлов. Если файлы не заданы, используется HS256 с общим секретом.
+func LoadKeySet(paths []string, secret []byte) (*KeySet, error) {
+	if len(paths) == 0 {
+		return NewKeySet(NewHMACKey(secret)), nil
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := LoadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys[0], keys[1:]...), nil
+}
+
+// SigningKey активный ключ подписи
+func (ks *KeySet) SigningKey() *SigningKey {
+	return ks.signing
+}
+
+// VerificationKey ключ проверки по kid
+func (ks *KeySet) VerificationKey(kid string) (*SigningKey, bool) {
+	for _, key := range ks.keys {
+		if key.KID == kid {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// VerificationKeys все ключи, пригодные для проверки
+func (ks *KeySet) VerificationKeys() []*SigningKey {
+	return ks.keys
+}
+
+// Keyfunc выбирает ключ проверки по kid из заголовка JWT. Алгоритм токена должен совпадать
+// с алгоритмом ключа, иначе возможна подмена алгоритма (например, HS256 с публичным ключом).
+func Keyfunc(provider KeyProvider) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := provider.VerificationKey(kid)
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, ErrInvalidSigningMethod
+		}
+		return key.verifyKey(), nil
+	}
+}
+
+// BuildJWKS формирует набор публичных ключей для /.well-known/jwks.json
+func BuildJWKS(provider KeyProvider) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range provider.VerificationKeys() {
+		if !key.IsAsymmetric() {
+			continue
+		}
+		if jwk, err := key.JWK(); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
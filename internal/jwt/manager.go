@@ -0,0 +1,335 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go_oauth2_server/internal/models"
+)
+
+// minReloadInterval минимальный интервал между внеплановыми перечитываниями ключей из хранилища
+const minReloadInterval = 10 * time.Second
+
+// KeyStore хранилище ключей подписи
+type KeyStore interface {
+	// ListSigningKeys активный ключ и ключи, еще пригодные для проверки
+	ListSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	// RotateSigningKey выводит ключ currentKID из подписи до retireAfter и сохраняет новый активный ключ
+	RotateSigningKey(ctx context.Context, currentKID string, key models.SigningKey, retireAfter time.Time) error
+	// DeleteExpiredSigningKeys удаляет ключи, вышедшие из окна проверки
+	DeleteExpiredSigningKeys(ctx context.Context) (int64, error)
+}
+
+// KeyCipher шифрует приватные ключи подписи перед сохранением в хранилище
+type KeyCipher interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(ciphertext string) (string, error)
+}
+
+// KeyManager управляет ротацией ключей подписи: держит один активный ключ и ключи,
+// которые только проверяют ранее выданные токены. Выведенный из подписи ключ остается
+// в JWKS, пока не истечет самый долгоживущий подписанный им access токен.
+type KeyManager struct {
+	store       KeyStore
+	cipher      KeyCipher
+	algorithm   string
+	retireAfter time.Duration
+	logger      *slog.Logger
+
+	mu         sync.RWMutex
+	set        *KeySet
+	activeAt   time.Time
+	lastReload time.Time
+}
+
+// NewKeyManager создает менеджер ключей. cipher шифрует приватные ключи в хранилище, algorithm задает
+// алгоритм новых ключей (RS256, ES256, EdDSA), retireAfter — максимальное время жизни access токена.
+func NewKeyManager(store KeyStore, cipher KeyCipher, algorithm string, retireAfter time.Duration, logger *slog.Logger) (*KeyManager, error) {
+	switch algorithm {
+	case "RS256", "ES256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm for key rotation: %s", algorithm)
+	}
+
+	return &KeyManager{
+		store:       store,
+		cipher:      cipher,
+		algorithm:   algorithm,
+		retireAfter: retireAfter,
+		logger:      logger,
+	}, nil
+}
+
+// Load перечитывает ключи из хранилища. Если активного ключа нет, создает его.
+func (m *KeyManager) Load(ctx context.Context) error {
+	return m.load(ctx, true)
+}
+
+// load перечитывает ключи; generate разрешает создать активный ключ, если его нет
+func (m *KeyManager) load(ctx context.Context, generate bool) error {
+	records, err := m.store.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		active       *SigningKey
+		activeAt     time.Time
+		verification []*SigningKey
+	)
+	for _, record := range records {
+		key, err := signingKeyFromRecord(record, m.cipher)
+		if err != nil {
+			m.logger.Error("Failed to load signing key", "kid", record.KID, "error", err)
+			continue
+		}
+		if record.RetiredAt == nil && active == nil {
+			active, activeAt = key, record.CreatedAt
+			continue
+		}
+		verification = append(verification, key)
+	}
+
+	if active == nil {
+		if !generate {
+			return fmt.Errorf("no active signing key in store")
+		}
+		m.logger.Info("No active signing key found, generating a new one", "alg", m.algorithm)
+		_, err := m.rotate(ctx, "")
+		return err
+	}
+
+	m.mu.Lock()
+	m.set = NewKeySet(active, verification...)
+	m.activeAt = activeAt
+	m.lastReload = time.Now()
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Rotate создает новый активный ключ, переводя текущий в режим только проверки
+func (m *KeyManager) Rotate(ctx context.Context) (*SigningKey, error) {
+	currentKID := ""
+	if current := m.SigningKey(); current != nil {
+		currentKID = current.KID
+	}
+	return m.rotate(ctx, currentKID)
+}
+
+// rotate заменяет активный ключ currentKID новым (пустой currentKID — первый ключ)
+func (m *KeyManager) rotate(ctx context.Context, currentKID string) (*SigningKey, error) {
+	key, err := GenerateSigningKey(m.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := MarshalPrivateKeyPEM(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err = m.cipher.Encrypt(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	record := models.SigningKey{
+		KID:        key.KID,
+		Algorithm:  key.Method.Alg(),
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	}
+	// Реплики с устаревшим набором ключей подписывают прежним ключом еще до minReloadInterval
+	retireAfter := time.Now().Add(m.retireAfter + minReloadInterval)
+	if err := m.store.RotateSigningKey(ctx, currentKID, record, retireAfter); err != nil {
+		if errors.Is(err, ErrRotationConflict) {
+			// Ротацию уже выполнил другой экземпляр сервера — берем его ключ
+			m.logger.Warn("Signing key rotation conflict, reloading keys", "error", err)
+			if err := m.load(ctx, false); err != nil {
+				return nil, err
+			}
+			return m.SigningKey(), nil
+		}
+		return nil, err
+	}
+
+	m.logger.Info("Signing key rotated", "kid", key.KID, "previous_kid", currentKID, "alg", record.Algorithm)
+
+	return key, m.load(ctx, false)
+}
+
+// Run периодически перечитывает ключи (их могла сменить другая реплика или CLI),
+// выполняет плановую ротацию и удаляет ключи, вышедшие из окна проверки
+func (m *KeyManager) Run(ctx context.Context, rotationInterval, reloadInterval time.Duration) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := m.Load(ctx); err != nil {
+			m.logger.Error("Failed to reload signing keys", "error", err)
+			continue
+		}
+
+		m.mu.RLock()
+		activeAt := m.activeAt
+		m.mu.RUnlock()
+
+		if rotationInterval > 0 && time.Since(activeAt) >= rotationInterval {
+			if _, err := m.Rotate(ctx); err != nil {
+				m.logger.Error("Scheduled signing key rotation failed", "error", err)
+			}
+		}
+
+		if deleted, err := m.store.DeleteExpiredSigningKeys(ctx); err != nil {
+			m.logger.Error("Failed to delete expired signing keys", "error", err)
+		} else if deleted > 0 {
+			m.logger.Info("Expired signing keys deleted", "rows_affected", deleted)
+		}
+	}
+}
+
+// SigningKey активный ключ подписи. Другая реплика могла уже вывести его из подписи, поэтому
+// ключи перечитываются, если с прошлого чтения прошло minReloadInterval: выведенный ключ
+// подписывает не дольше этого интервала, и подписанные им токены истекают до его retire_after.
+func (m *KeyManager) SigningKey() *SigningKey {
+	m.mu.Lock()
+	stale := m.set != nil && time.Since(m.lastReload) >= minReloadInterval
+	if stale {
+		m.lastReload = time.Now()
+	}
+	m.mu.Unlock()
+
+	if stale {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := m.load(ctx, false); err != nil {
+			m.logger.Error("Failed to reload signing keys", "error", err)
+		}
+		cancel()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.set == nil {
+		return nil
+	}
+	return m.set.SigningKey()
+}
+
+// VerificationKey ключ проверки по kid. Неизвестный kid может означать, что другая реплика
+// уже выполнила ротацию, поэтому ключи перечитываются, но не чаще minReloadInterval.
+func (m *KeyManager) VerificationKey(kid string) (*SigningKey, bool) {
+	m.mu.RLock()
+	set, lastReload := m.set, m.lastReload
+	m.mu.RUnlock()
+
+	if set != nil {
+		if key, ok := set.VerificationKey(kid); ok {
+			return key, true
+		}
+	}
+
+	if time.Since(lastReload) < minReloadInterval {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	m.mu.Lock()
+	m.lastReload = time.Now()
+	m.mu.Unlock()
+
+	if err := m.Load(ctx); err != nil {
+		m.logger.Error("Failed to reload signing keys", "error", err)
+		return nil, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.set.VerificationKey(kid)
+}
+
+// VerificationKeys все ключи, пригодные для проверки
+func (m *KeyManager) VerificationKeys() []*SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.set == nil {
+		return nil
+	}
+	return m.set.VerificationKeys()
+}
+
+// GenerateSigningKey создает новый ключ для алгоритма RS256, ES256 или EdDSA
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm for key generation: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+
+	return NewSigningKey(privateKey)
+}
+
+// MarshalPrivateKeyPEM кодирует приватный ключ в PEM (PKCS#8)
+func MarshalPrivateKeyPEM(privateKey crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// signingKeyFromRecord расшифровывает и восстанавливает ключ из записи хранилища
+func signingKeyFromRecord(record models.SigningKey, cipher KeyCipher) (*SigningKey, error) {
+	privateKeyPEM, err := cipher.Decrypt(record.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing key %s: %w", record.KID, err)
+	}
+
+	privateKey, err := ParsePrivateKeyPEM([]byte(privateKeyPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewSigningKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if key.KID != record.KID || key.Method.Alg() != record.Algorithm {
+		return nil, fmt.Errorf("signing key %s does not match stored metadata", record.KID)
+	}
+	return key, nil
+}
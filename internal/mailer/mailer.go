@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer отправляет письма пользователям
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Message текстовое письмо
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// errHeaderInjection переводы строк в адресе или теме письма
+var errHeaderInjection = errors.New("mail header contains line break")
+
+// validate отклоняет письмо, заголовки которого можно было бы дополнить через значения полей
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errHeaderInjection
+	}
+	if m.To == "" {
+		return errors.New("mail recipient is empty")
+	}
+	return nil
+}
+
+// encode письмо в формате RFC 5322 с телом в quoted-printable
+func (m Message) encode(from string) ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate message id: %w", err)
+	}
+	domain := "localhost"
+	if _, host, found := strings.Cut(from, "@"); found {
+		domain = strings.Trim(host, "> ")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	if _, err := body.Write([]byte(strings.ReplaceAll(m.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Log записывает письма в лог вместо отправки. Для локальной разработки: ссылки из писем видны в логе
+type Log struct {
+	logger *slog.Logger
+}
+
+// NewLog создает отправку писем в лог
+func NewLog(logger *slog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	l.logger.Info("Mail message", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// File сохраняет каждое письмо в отдельный файл .eml в каталоге. Для локальной разработки и тестов
+type File struct {
+	dir  string
+	from string
+}
+
+// NewFile создает отправку писем в каталог dir, создавая его при необходимости
+func NewFile(dir, from string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &File{dir: dir, from: from}, nil
+}
+
+func (f *File) Send(ctx context.Context, msg Message) error {
+	data, err := msg.encode(f.from)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to generate file name: %w", err)
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	if err := os.WriteFile(filepath.Join(f.dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+	return nil
+}
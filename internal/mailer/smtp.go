@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTP отправляет письма через SMTP сервер. Если сервер поддерживает STARTTLS, соединение шифруется
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+	// sender адрес отправителя без имени для команды MAIL FROM
+	sender string
+}
+
+// NewSMTP создает отправку писем через host:port. Без username сервер используется без аутентификации
+func NewSMTP(host string, port int, username, password, from string) (*SMTP, error) {
+	address, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	s := &SMTP{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		from:   address.String(),
+		sender: address.Address,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := msg.encode(s.from)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp не принимает контекст: отправка прерывается только таймаутами соединения
+	if err := smtp.SendMail(s.addr, s.auth, s.sender, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
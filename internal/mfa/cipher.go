@@ -0,0 +1,66 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Cipher шифрует секреты второго фактора AES-256-GCM. Ключ выводится из секрета конфигурации
+type Cipher struct {
+	key [32]byte
+}
+
+// NewCipher создает шифр с ключом SHA-256(secret)
+func NewCipher(secret string) *Cipher {
+	return &Cipher{key: sha256.Sum256([]byte(secret))}
+}
+
+// Encrypt шифрует значение. Результат — base64 от nonce и шифротекста
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// Decrypt расшифровывает значение, зашифрованное Encrypt
+func (c *Cipher) Decrypt(ciphertext string) (string, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// aead AES-GCM с ключом шифра
+func (c *Cipher) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,39 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Одноразовые коды восстановления на случай потери приложения-аутентификатора
+const (
+	// RecoveryCodeCount число кодов в наборе
+	RecoveryCodeCount = 10
+	// recoveryCodeBytes энтропия кода: 10 байт дают 16 символов base32
+	recoveryCodeBytes = 10
+)
+
+// GenerateRecoveryCodes создает набор кодов восстановления вида xxxx-xxxx-xxxx-xxxx
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := strings.ToLower(secretEncoding.EncodeToString(b))
+		codes[i] = encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode приводит введенный код к виду без разделителей в нижнем регистре
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,92 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Параметры TOTP (RFC 6238), которые поддерживают все распространенные приложения-аутентификаторы
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// totpSkew допустимое расхождение часов в шагах в каждую сторону
+	totpSkew = 1
+	// secretBytes длина секрета TOTP, рекомендуемая RFC 4226 для HMAC-SHA1
+	secretBytes = 20
+)
+
+// secretEncoding кодирование секрета для приложений-аутентификаторов
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret создает случайный секрет TOTP в base32
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return secretEncoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI адрес otpauth:// для добавления секрета в приложение-аутентификатор.
+// Этот же адрес кодируется в QR код
+func ProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(int(totpPeriod.Seconds()))},
+	}
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// ValidateTOTP проверяет код на момент now с учетом расхождения часов. Коды шагов не новее
+// lastStep отклоняются, чтобы один код нельзя было использовать дважды. Возвращает шаг кода
+func ValidateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := secretEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode код HOTP (RFC 4226 §5.3) для шага step
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -1,15 +1,102 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Client struct {
-	ID        string    `json:"id" db:"id"`
-	Secret    string    `json:"secret" db:"secret"`
-	Domain    string    `json:"domain" db:"domain"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID          string `json:"id" db:"id"`
+	Secret      string `json:"secret" db:"secret"`
+	Domain      string `json:"domain" db:"domain"`
+	UserID      string `json:"user_id" db:"user_id"`
+	Public      bool   `json:"is_public" db:"is_public"`
+	RequirePKCE bool   `json:"require_pkce" db:"require_pkce"`
+	// RedirectURIs зарегистрированные redirect URI, сравниваются с запросом посимвольно
+	RedirectURIs []string `json:"redirect_uris" db:"redirect_uris"`
+	// GrantTypes и ResponseTypes разрешенные клиенту grant types и response types (RFC 7591 §2)
+	GrantTypes    []string `json:"grant_types" db:"grant_types"`
+	ResponseTypes []string `json:"response_types" db:"response_types"`
+	// AllowedScopes разрешенные клиенту scope. Пустой список — все scope, кроме restricted
+	AllowedScopes []string `json:"allowed_scopes" db:"allowed_scopes"`
+	// UserinfoSignedResponseAlg алгоритм подписи ответа /userinfo, пустое значение — ответ в JSON
+	UserinfoSignedResponseAlg string    `json:"userinfo_signed_response_alg,omitempty" db:"userinfo_signed_response_alg"`
+	CreatedAt                 time.Time `json:"created_at" db:"created_at"`
+	// PreviousSecret хеш секрета до ротации, принимается до PreviousSecretExpiresAt
+	PreviousSecret          string     `json:"-" db:"previous_secret"`
+	PreviousSecretExpiresAt *time.Time `json:"-" db:"previous_secret_expires_at"`
+	UpdatedAt               time.Time  `json:"updated_at" db:"updated_at"`
+	// DeletedAt время мягкого удаления. Удаленный клиент не аутентифицируется и не получает токены
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// ClientDetails клиент в административном API, без хешей секретов
+type ClientDetails struct {
+	ClientID      string   `json:"client_id"`
+	Domain        string   `json:"domain"`
+	UserID        string   `json:"user_id,omitempty"`
+	Public        bool     `json:"is_public"`
+	RequirePKCE   bool     `json:"require_pkce"`
+	RedirectURIs  []string `json:"redirect_uris"`
+	GrantTypes    []string `json:"grant_types"`
+	ResponseTypes []string `json:"response_types"`
+	// Scope разрешенные клиенту scope через пробел, пустое значение — все, кроме restricted
+	Scope                     string `json:"scope,omitempty"`
+	UserinfoSignedResponseAlg string `json:"userinfo_signed_response_alg,omitempty"`
+	// PreviousSecretExpiresAt до этого времени принимается и секрет, действовавший до ротации
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+	UpdatedAt               time.Time  `json:"updated_at"`
+	DeletedAt               *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ClientFilter условия выборки клиентов. Пустые поля не ограничивают выборку
+type ClientFilter struct {
+	// Domain часть домена без учета регистра
+	Domain    string
+	UserID    string
+	GrantType string
+	Public    *bool
+	// IncludeDeleted включает в выборку удаленных клиентов
+	IncludeDeleted bool
+	Limit          int
+	Offset         int
+}
+
+// ClientList страница списка клиентов. Total — число клиентов по фильтру без учета limit и offset
+type ClientList struct {
+	Clients []ClientDetails `json:"clients"`
+	Total   int             `json:"total"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+}
+
+// ClientUpdate изменение клиента администратором. Поля без значения не меняются
+type ClientUpdate struct {
+	Domain        *string   `json:"domain,omitempty"`
+	RedirectURIs  *[]string `json:"redirect_uris,omitempty"`
+	GrantTypes    *[]string `json:"grant_types,omitempty"`
+	ResponseTypes *[]string `json:"response_types,omitempty"`
+	// Scope разрешенные scope через пробел, в том числе restricted. Пустая строка снимает ограничение
+	Scope       *string `json:"scope,omitempty"`
+	RequirePKCE *bool   `json:"require_pkce,omitempty"`
+	// UserinfoSignedResponseAlg пустая строка возвращает ответ /userinfo в JSON
+	UserinfoSignedResponseAlg *string `json:"userinfo_signed_response_alg,omitempty"`
+}
+
+// ClientSecretRotation параметры ротации секрета клиента
+type ClientSecretRotation struct {
+	// GracePeriodSeconds сколько секунд принимается прежний секрет, по умолчанию CLIENT_SECRET_GRACE_HOURS.
+	// 0 отзывает прежний секрет сразу
+	GracePeriodSeconds *int64 `json:"grace_period_seconds,omitempty"`
+}
+
+// ClientSecret новый секрет клиента. Показывается один раз
+type ClientSecret struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// PreviousSecretExpiresAt до этого времени принимается и прежний секрет
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
 }
 
 type User struct {
@@ -17,16 +104,277 @@ type User struct {
 	Username  string    `json:"username" db:"username"`
 	Password  string    `json:"password" db:"password"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
+
+	// Профиль пользователя (OpenID Connect Core §5.1)
+	Name                string    `json:"name,omitempty" db:"name"`
+	GivenName           string    `json:"given_name,omitempty" db:"given_name"`
+	FamilyName          string    `json:"family_name,omitempty" db:"family_name"`
+	Nickname            string    `json:"nickname,omitempty" db:"nickname"`
+	Picture             string    `json:"picture,omitempty" db:"picture"`
+	Locale              string    `json:"locale,omitempty" db:"locale"`
+	Zoneinfo            string    `json:"zoneinfo,omitempty" db:"zoneinfo"`
+	Email               string    `json:"email,omitempty" db:"email"`
+	EmailVerified       bool      `json:"email_verified" db:"email_verified"`
+	PhoneNumber         string    `json:"phone_number,omitempty" db:"phone_number"`
+	PhoneNumberVerified bool      `json:"phone_number_verified" db:"phone_number_verified"`
+	Address             *Address  `json:"address,omitempty" db:"address"`
+	UpdatedAt           time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Address почтовый адрес пользователя (OpenID Connect Core §5.1.1)
+type Address struct {
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"street_address,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	Country       string `json:"country,omitempty"`
+}
+
+// Session сессия пользователя, вошедшего через страницу входа
+type Session struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	AuthTime  time.Time `json:"auth_time" db:"auth_time"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	IPAddress string    `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent string    `json:"user_agent,omitempty" db:"user_agent"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// AMR методы аутентификации при входе (RFC 8176): pwd, otp, hwk
+	AMR []string `json:"amr" db:"amr"`
+}
+
+// Scope зарегистрированный scope
+type Scope struct {
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	// Default выдается, если клиент не запросил scope
+	Default bool `json:"is_default" db:"is_default"`
+	// Restricted доступен только клиентам, которым назначен явно
+	Restricted bool      `json:"restricted" db:"restricted"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// Consent согласие пользователя на доступ клиента к перечисленным scope
+type Consent struct {
+	UserID    string    `json:"user_id" db:"user_id"`
+	ClientID  string    `json:"client_id" db:"client_id"`
+	Scope     string    `json:"scope" db:"scope"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// LoginFailures неудачные попытки входа по имени пользователя или IP адресу
+type LoginFailures struct {
+	SubjectType   string     `json:"subject_type" db:"subject_type"`
+	Subject       string     `json:"subject" db:"subject"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+}
+
+// TOTP второй фактор пользователя. Secret зашифрован, ConfirmedAt пуст до подтверждения первым кодом
+type TOTP struct {
+	UserID       string     `json:"user_id" db:"user_id"`
+	Secret       string     `json:"-" db:"secret"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty" db:"confirmed_at"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// TOTPCodeRequest код TOTP или код восстановления
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPEnrollment данные для добавления секрета в приложение-аутентификатор
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// ProvisioningURI адрес otpauth://, он же содержимое QR кода
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TOTPStatus состояние второго фактора пользователя
+type TOTPStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// RecoveryCodes коды восстановления. Показываются один раз при создании
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// UserToken одноразовый токен из письма: подтверждение адреса или сброс пароля
+type UserToken struct {
+	UserID    string    `json:"user_id" db:"user_id"`
+	Purpose   string    `json:"purpose" db:"purpose"`
+	Email     string    `json:"email" db:"email"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// EmailRequest запрос письма на адрес электронной почты
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetRequest новый пароль по токену из письма сброса пароля
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Profile профиль пользователя в API учетной записи /me
+type Profile struct {
+	ID                  string    `json:"id"`
+	Username            string    `json:"username"`
+	Name                string    `json:"name,omitempty"`
+	GivenName           string    `json:"given_name,omitempty"`
+	FamilyName          string    `json:"family_name,omitempty"`
+	Nickname            string    `json:"nickname,omitempty"`
+	Picture             string    `json:"picture,omitempty"`
+	Locale              string    `json:"locale,omitempty"`
+	Zoneinfo            string    `json:"zoneinfo,omitempty"`
+	Email               string    `json:"email,omitempty"`
+	EmailVerified       bool      `json:"email_verified"`
+	PhoneNumber         string    `json:"phone_number,omitempty"`
+	PhoneNumberVerified bool      `json:"phone_number_verified"`
+	Address             *Address  `json:"address,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// ProfileUpdate изменение профиля. Поля без значения не меняются, пустая строка очищает поле,
+// пустой address удаляет адрес
+type ProfileUpdate struct {
+	Name        *string  `json:"name,omitempty"`
+	GivenName   *string  `json:"given_name,omitempty"`
+	FamilyName  *string  `json:"family_name,omitempty"`
+	Nickname    *string  `json:"nickname,omitempty"`
+	Picture     *string  `json:"picture,omitempty"`
+	Locale      *string  `json:"locale,omitempty"`
+	Zoneinfo    *string  `json:"zoneinfo,omitempty"`
+	Email       *string  `json:"email,omitempty"`
+	PhoneNumber *string  `json:"phone_number,omitempty"`
+	Address     *Address `json:"address,omitempty"`
+}
+
+// PasswordChangeRequest смена пароля с подтверждением текущим паролем
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// AccountDeleteRequest подтверждение удаления учетной записи паролем
+type AccountDeleteRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountToken выданный пользователю грант: семейство access и refresh токенов одного входа в клиент
+type AccountToken struct {
+	// ID идентификатор семейства токенов
+	ID       string `json:"id"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	// CreatedAt выдача первого действующего токена семейства, IssuedAt — последнего
+	CreatedAt time.Time `json:"created_at"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Current токен, которым выполнен запрос, принадлежит этому семейству
+	Current bool `json:"current"`
+}
+
+// AccountSession сессия страницы входа в API учетной записи. ID — хеш идентификатора сессии:
+// сам идентификатор является секретом cookie
+type AccountSession struct {
+	ID        string    `json:"id"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	AMR       []string  `json:"amr"`
+	AuthTime  time.Time `json:"auth_time"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Passkey ключ WebAuthn пользователя
+type Passkey struct {
+	ID              []byte     `json:"-" db:"id"`
+	UserID          string     `json:"-" db:"user_id"`
+	Name            string     `json:"name" db:"name"`
+	PublicKey       []byte     `json:"-" db:"public_key"`
+	AttestationType string     `json:"-" db:"attestation_type"`
+	AAGUID          []byte     `json:"-" db:"aaguid"`
+	SignCount       uint32     `json:"-" db:"sign_count"`
+	Transports      []string   `json:"-" db:"transports"`
+	BackupEligible  bool       `json:"-" db:"backup_eligible"`
+	BackupState     bool       `json:"-" db:"backup_state"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+// PasskeyInfo ключ WebAuthn в списке ключей пользователя
+type PasskeyInfo struct {
+	// ID идентификатор учетных данных в base64url
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Synced     bool       `json:"synced"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnChallenge незавершенная церемония WebAuthn. SessionData — состояние церемонии в JSON
+type WebAuthnChallenge struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id,omitempty" db:"user_id"`
+	Ceremony    string    `json:"ceremony" db:"ceremony"`
+	SessionData []byte    `json:"-" db:"session_data"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// WebAuthnOptions параметры для navigator.credentials.create() или navigator.credentials.get()
+type WebAuthnOptions struct {
+	ChallengeID string      `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+// WebAuthnResponse ответ аутентификатора на церемонию challenge_id
+type WebAuthnResponse struct {
+	ChallengeID string          `json:"challenge_id"`
+	Credential  json.RawMessage `json:"credential"`
+	// Name название нового ключа, только при регистрации
+	Name string `json:"name,omitempty"`
+	// ReturnTo относительный адрес для возврата после входа
+	ReturnTo string `json:"return_to,omitempty"`
+}
+
+// LoginRedirect адрес, на который страница входа переходит после входа по ключу
+type LoginRedirect struct {
+	Redirect string `json:"redirect"`
+}
+
+// SigningKey ключ подписи JWT, хранимый для ротации
+type SigningKey struct {
+	KID         string     `json:"kid" db:"kid"`
+	Algorithm   string     `json:"alg" db:"algorithm"`
+	PrivateKey  string     `json:"-" db:"private_key"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" db:"retired_at"`
+	RetireAfter *time.Time `json:"retire_after,omitempty" db:"retire_after"`
 }
 
 type AuthorizeRequest struct {
-	ResponseType string `json:"response_type"`
-	ClientID     string `json:"client_id"`
-	RedirectURI  string `json:"redirect_uri"`
-	Scope        string `json:"scope"`
-	State        string `json:"state"`
-	Username     string `json:"username"`
-	Password     string `json:"password"`
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	Username            string `json:"username"`
+	Password            string `json:"password"`
+	// OTP код TOTP или код восстановления для пользователя со вторым фактором
+	OTP string `json:"otp,omitempty"`
 }
 
 type TokenRequest struct {
@@ -35,6 +383,7 @@ type TokenRequest struct {
 	RedirectURI  string `json:"redirect_uri"`
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
 	RefreshToken string `json:"refresh_token"`
 	Username     string `json:"username"`
 	Password     string `json:"password"`
@@ -47,6 +396,8 @@ type TokenResponse struct {
 	ExpiresIn    int64  `json:"expires_in"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	Scope        string `json:"scope,omitempty"`
+	// IDToken выдается при запросе scope openid (OpenID Connect Core §3.1.3.3)
+	IDToken string `json:"id_token,omitempty"`
 }
 
 type IntrospectRequest struct {
@@ -54,10 +405,22 @@ type IntrospectRequest struct {
 	TokenTypeHint string `json:"token_type_hint,omitempty"`
 }
 
+// IntrospectResponse ответ интроспекции (RFC 7662 §2.2)
 type IntrospectResponse struct {
-	Active   bool   `json:"active"`
-	ClientID string `json:"client_id,omitempty"`
-	UserID   string `json:"user_id,omitempty"`
-	Scope    string `json:"scope,omitempty"`
-	Exp      int64  `json:"exp,omitempty"`
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	// AMR методы аутентификации пользователя (RFC 8176)
+	AMR []string `json:"amr,omitempty"`
+	// UserID дублирует sub и оставлен для обратной совместимости
+	UserID string `json:"user_id,omitempty"`
 }
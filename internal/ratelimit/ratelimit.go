@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval интервал удаления заполненных корзин из памяти
+const sweepInterval = time.Minute
+
+// Limit параметры token bucket: корзина вмещает Burst запросов и пополняется PerMinute
+// запросами в минуту. Нулевой PerMinute отключает ограничение
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// Enabled проверяет, что ограничение включено
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0
+}
+
+// perSecond скорость пополнения корзины
+func (l Limit) perSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// capacity емкость корзины, не меньше одного запроса
+func (l Limit) capacity() float64 {
+	return float64(max(l.Burst, 1))
+}
+
+// retryAfter время до появления в корзине целого токена
+func (l Limit) retryAfter(tokens float64) time.Duration {
+	seconds := math.Ceil((1 - tokens) / l.perSecond())
+	return time.Duration(max(seconds, 1)) * time.Second
+}
+
+// Limiter списывает запрос из корзины key. Если корзина пуста, возвращает false и время,
+// через которое запрос можно повторить
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// Memory корзины в памяти процесса. Лимиты действуют отдельно на каждой реплике
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket состояние корзины
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// NewMemory создает ограничитель с корзинами в памяти
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// Allow списывает запрос из корзины key
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.capacity(), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = b.available(now)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, limit.retryAfter(b.tokens), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// available токены корзины на момент now
+func (b *bucket) available(now time.Time) float64 {
+	return min(b.limit.capacity(), b.tokens+now.Sub(b.updated).Seconds()*b.limit.perSecond())
+}
+
+// sweep удаляет заполненные корзины: их состояние не отличается от новой
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if b.available(now) >= b.limit.capacity() {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Store хранилище общих корзин
+type Store interface {
+	// TakeRateLimitToken пополняет корзину key и списывает из нее запрос, если в ней есть целый
+	// токен. Возвращает результат и остаток токенов
+	TakeRateLimitToken(ctx context.Context, key string, perSecond float64, capacity float64) (bool, float64, error)
+}
+
+// Shared корзины в хранилище, общие для всех реплик сервера
+type Shared struct {
+	store Store
+}
+
+// NewShared создает ограничитель с корзинами в хранилище
+func NewShared(store Store) *Shared {
+	return &Shared{store: store}
+}
+
+// Allow списывает запрос из общей корзины key
+func (s *Shared) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	allowed, tokens, err := s.store.TakeRateLimitToken(ctx, key, limit.perSecond(), limit.capacity())
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	if !allowed {
+		return false, limit.retryAfter(tokens), nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+)
+
+// UpdateUserProfile сохраняет профиль пользователя. При смене адреса электронной почты или телефона
+// отметка о его подтверждении снимается
+func (s *PostgresStore) UpdateUserProfile(ctx context.Context, user *models.User) error {
+	address, err := marshalAddress(user.Address)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE users SET
+            name = NULLIF($2, ''), given_name = NULLIF($3, ''), family_name = NULLIF($4, ''),
+            nickname = NULLIF($5, ''), picture = NULLIF($6, ''), locale = NULLIF($7, ''), zoneinfo = NULLIF($8, ''),
+            email_verified = email_verified AND LOWER(COALESCE(email, '')) = LOWER($9),
+            email = NULLIF($9, ''),
+            phone_number_verified = phone_number_verified AND COALESCE(phone_number, '') = $10,
+            phone_number = NULLIF($10, ''),
+            address = $11,
+            updated_at = NOW()
+        WHERE id = $1
+    `
+	_, err = s.db.ExecContext(ctx, query,
+		user.ID, user.Name, user.GivenName, user.FamilyName,
+		user.Nickname, user.Picture, user.Locale, user.Zoneinfo,
+		user.Email, user.PhoneNumber, address,
+	)
+	if err != nil {
+		if isUniqueViolation(err, emailIndex) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to update user profile: %w", err)
+	}
+	return nil
+}
+
+// ChangePassword заменяет пароль по запросу пользователя. Сессии, ссылки сброса пароля
+// и неиспользованные authorization codes удаляются, токены отзываются, кроме семейства токена
+// currentAccess, которым выполнен запрос
+func (s *PostgresStore) ChangePassword(ctx context.Context, userID, password, currentAccess string) error {
+	if err := s.replacePassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	condition := `user_id = $1 AND family_id NOT IN (
+            SELECT family_id FROM oauth2_tokens WHERE access_token_hash = $2
+        )`
+	if _, err := revokeTokensWhere(ctx, s.db, condition, userID, s.tokenHasher.Hash(currentAccess)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// replacePassword сохраняет хеш нового пароля и в той же транзакции удаляет ссылки сброса пароля,
+// сессии и неиспользованные authorization codes пользователя
+func (s *PostgresStore) replacePassword(ctx context.Context, userID, password string) error {
+	hash, err := s.passwordHasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password = $1, updated_at = NOW() WHERE id = $2`, hash, userID); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	query := `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID, UserTokenPasswordReset); err != nil {
+		return fmt.Errorf("failed to delete password reset tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth2_authorization_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete authorization codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListUserTokens получает действующие гранты пользователя: по одной записи на семейство токенов
+// с последним выданным токеном. currentAccess отмечает семейство токена текущего запроса
+func (s *PostgresStore) ListUserTokens(ctx context.Context, userID, currentAccess string) ([]models.AccountToken, error) {
+	query := `
+        SELECT id, client_id, scope, first_issued_at, issued_at, expires_at, is_current
+        FROM (
+            SELECT DISTINCT ON (family_id)
+                   family_id::TEXT AS id, client_id, COALESCE(scope, '') AS scope,
+                   MIN(created_at) OVER (PARTITION BY family_id) AS first_issued_at,
+                   created_at AS issued_at,
+                   GREATEST(access_expires_at, COALESCE(refresh_expires_at, access_expires_at)) AS expires_at,
+                   family_id IN (SELECT family_id FROM oauth2_tokens WHERE access_token_hash = $2) AS is_current
+            FROM oauth2_tokens
+            WHERE user_id = $1 AND (
+                access_expires_at > NOW()
+                OR (refresh_token_hash IS NOT NULL AND refresh_used_at IS NULL
+                    AND (refresh_expires_at IS NULL OR refresh_expires_at > NOW()))
+            )
+            ORDER BY family_id, created_at DESC
+        ) families
+        ORDER BY issued_at DESC
+    `
+
+	rows, err := s.db.QueryContext(ctx, query, userID, s.tokenHasher.Hash(currentAccess))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]models.AccountToken, 0)
+	for rows.Next() {
+		var token models.AccountToken
+		if err := rows.Scan(
+			&token.ID, &token.ClientID, &token.Scope, &token.CreatedAt, &token.IssuedAt, &token.ExpiresAt, &token.Current,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan user token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list user tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeUserTokenFamily отзывает семейство токенов пользователя. Возвращает false, если у пользователя
+// нет токенов этого семейства
+func (s *PostgresStore) RevokeUserTokenFamily(ctx context.Context, userID, familyID string) (bool, error) {
+	revoked, err := revokeTokensWhere(ctx, s.db, "family_id = $1 AND user_id = $2", familyID, userID)
+	if err != nil {
+		return false, err
+	}
+	return revoked > 0, nil
+}
+
+// ListUserSessions получает действующие сессии пользователя. Вместо идентификатора сессии
+// возвращается его HMAC хеш
+func (s *PostgresStore) ListUserSessions(ctx context.Context, userID string) ([]models.AccountSession, error) {
+	query := `
+        SELECT id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), amr, auth_time, created_at, expires_at
+        FROM sessions
+        WHERE user_id = $1 AND expires_at > NOW()
+        ORDER BY created_at DESC
+    `
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]models.AccountSession, 0)
+	for rows.Next() {
+		var session models.AccountSession
+		var id, amr string
+		if err := rows.Scan(
+			&id, &session.IPAddress, &session.UserAgent, &amr, &session.AuthTime, &session.CreatedAt, &session.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		session.ID = s.tokenHasher.Hash(id)
+		session.AMR = strings.Fields(amr)
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession завершает сессию пользователя по хешу ее идентификатора из ListUserSessions.
+// Возвращает false, если такой сессии нет
+func (s *PostgresStore) DeleteUserSession(ctx context.Context, userID, sessionHash string) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	// Идентификаторы хранятся в открытом виде, поэтому хеш сравнивается на стороне приложения
+	sessionID := ""
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return false, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(s.tokenHasher.Hash(id)), []byte(sessionHash)) == 1 {
+			sessionID = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if sessionID == "" {
+		return false, nil
+	}
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_id = $2`, sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// DeleteUser удаляет учетную запись. Сессии, согласия, второй фактор, ключи доступа и ссылки из писем
+// удаляются каскадно, authorization codes — в той же транзакции. Выданные токены отзываются после
+// удаления пользователя, чтобы по refresh токену нельзя было успеть получить новый
+func (s *PostgresStore) DeleteUser(ctx context.Context, userID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth2_authorization_codes WHERE user_id = $1`, userID); err != nil {
+		return false, fmt.Errorf("failed to delete authorization codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if _, err := revokeTokensWhere(ctx, s.db, "user_id = $1", userID); err != nil {
+		return true, err
+	}
+	return true, nil
+}
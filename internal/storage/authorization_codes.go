@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go_oauth2_server/internal/jwt"
+
+	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// Ключи расширений токена. Менеджер OAuth2 копирует расширения кода в выдаваемый токен,
+// поэтому связь токена с кодом, nonce и время аутентификации сохраняются без изменений в библиотеке.
+const (
+	extAuthorizationCode = jwt.ExtensionAuthorizationCode
+	extJTI               = jwt.ExtensionJTI
+	extNonce             = jwt.ExtensionNonce
+	extAuthTime          = jwt.ExtensionAuthTime
+	extAMR               = jwt.ExtensionAMR
+)
+
+// authorizationCode запись из таблицы oauth2_authorization_codes
+type authorizationCode struct {
+	token    *models.Token
+	consumed bool
+}
+
+// createAuthorizationCode сохраняет authorization code
+func createAuthorizationCode(ctx context.Context, db *sql.DB, info oauth2.TokenInfo) error {
+	query := `
+        INSERT INTO oauth2_authorization_codes (
+            code, client_id, user_id, redirect_uri, scope, expires_at, created_at,
+            code_challenge, code_challenge_method, nonce, auth_time, amr
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+    `
+
+	createdAt := info.GetCodeCreateAt()
+	expiresAt := createdAt.Add(info.GetCodeExpiresIn())
+
+	_, err := db.ExecContext(ctx, query,
+		info.GetCode(),
+		info.GetClientID(),
+		info.GetUserID(),
+		info.GetRedirectURI(),
+		info.GetScope(),
+		expiresAt,
+		createdAt,
+		info.GetCodeChallenge(),
+		info.GetCodeChallengeMethod().String(),
+		tokenExtensionValue(info, extNonce),
+		tokenExtensionTime(info, extAuthTime),
+		tokenExtensionValue(info, extAMR),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+	return nil
+}
+
+// getAuthorizationCode получает authorization code, включая уже использованные
+func getAuthorizationCode(ctx context.Context, db *sql.DB, code string) (*authorizationCode, error) {
+	query := `
+        SELECT code, client_id, COALESCE(user_id, ''), COALESCE(redirect_uri, ''), COALESCE(scope, ''),
+               expires_at, consumed, created_at,
+               COALESCE(code_challenge, ''), COALESCE(code_challenge_method, ''),
+               nonce, auth_time, amr
+        FROM oauth2_authorization_codes
+        WHERE code = $1 AND expires_at > NOW()
+    `
+
+	var (
+		token                models.Token
+		expiresAt, createdAt time.Time
+		consumed             bool
+		nonce, amr           sql.NullString
+		authTime             sql.NullTime
+	)
+
+	err := db.QueryRowContext(ctx, query, code).Scan(
+		&token.Code,
+		&token.ClientID,
+		&token.UserID,
+		&token.RedirectURI,
+		&token.Scope,
+		&expiresAt,
+		&consumed,
+		&createdAt,
+		&token.CodeChallenge,
+		&token.CodeChallengeMethod,
+		&nonce,
+		&authTime,
+		&amr,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	token.CodeCreateAt = createdAt
+	token.CodeExpiresIn = expiresAt.Sub(createdAt)
+	token.Extension = tokenExtension(sql.NullString{String: code, Valid: true}, sql.NullString{}, authTime, amr)
+	if nonce.Valid && nonce.String != "" {
+		token.Extension.Set(extNonce, nonce.String)
+	}
+
+	return &authorizationCode{token: &token, consumed: consumed}, nil
+}
+
+// consumeAuthorizationCode помечает код использованным. Повторная пометка невозможна,
+// поэтому из двух одновременных обменов одного кода успешным будет только один.
+func consumeAuthorizationCode(ctx context.Context, db *sql.DB, code string) error {
+	query := `
+        UPDATE oauth2_authorization_codes
+        SET consumed = TRUE, consumed_at = NOW()
+        WHERE code = $1 AND consumed = FALSE
+    `
+
+	result, err := db.ExecContext(ctx, query, code)
+	if err != nil {
+		return fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return oauthErrors.ErrInvalidAuthorizeCode
+	}
+	return nil
+}
+
+// revokeTokensByAuthorizationCode отзывает все токены, выпущенные по коду (RFC 6749 §4.1.2)
+func revokeTokensByAuthorizationCode(ctx context.Context, db *sql.DB, code string) (int64, error) {
+	return revokeTokensWhere(ctx, db, "authorization_code = $1", code)
+}
+
+// tokenExtensionValue возвращает значение расширения токена или пустое значение
+func tokenExtensionValue(info oauth2.TokenInfo, key string) sql.NullString {
+	if eti, ok := info.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		if value := eti.GetExtension().Get(key); value != "" {
+			return sql.NullString{String: value, Valid: true}
+		}
+	}
+	return sql.NullString{}
+}
+
+// tokenExtensionTime возвращает время из расширения токена (секунды Unix) или пустое значение
+func tokenExtensionTime(info oauth2.TokenInfo, key string) sql.NullTime {
+	value := tokenExtensionValue(info, key)
+	if !value.Valid {
+		return sql.NullTime{}
+	}
+
+	seconds, err := strconv.ParseInt(value.String, 10, 64)
+	if err != nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.Unix(seconds, 0), Valid: true}
+}
+
+// tokenExtension восстанавливает расширения токена из сохраненных полей
+func tokenExtension(code, jti sql.NullString, authTime sql.NullTime, amr sql.NullString) url.Values {
+	ext := make(url.Values)
+	if code.Valid && code.String != "" {
+		ext.Set(extAuthorizationCode, code.String)
+	}
+	if jti.Valid && jti.String != "" {
+		ext.Set(extJTI, jti.String)
+	}
+	if authTime.Valid {
+		ext.Set(extAuthTime, strconv.FormatInt(authTime.Time.Unix(), 10))
+	}
+	if amr.Valid && amr.String != "" {
+		ext.Set(extAMR, amr.String)
+	}
+	return ext
+}
+
+// cleanExpiredAuthorizationCodes удаляет истекшие authorization codes
+func cleanExpiredAuthorizationCodes(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM oauth2_authorization_codes WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired authorization codes: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
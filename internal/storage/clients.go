@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// GetClientWithDeleted получает клиента, в том числе удаленного
+func (s *PostgresStore) GetClientWithDeleted(ctx context.Context, clientID string) (*models.Client, error) {
+	return s.getClient(ctx, "id = $1", clientID)
+}
+
+// ListClients получает страницу клиентов по фильтру, начиная с новых, и число клиентов по фильтру
+func (s *PostgresStore) ListClients(ctx context.Context, filter models.ClientFilter) ([]models.Client, int, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if filter.Domain != "" {
+		where("domain ILIKE '%' || ? || '%'", escapeLike(filter.Domain))
+	}
+	if filter.UserID != "" {
+		where("user_id = ?", filter.UserID)
+	}
+	if filter.GrantType != "" {
+		where("? = ANY(grant_types)", filter.GrantType)
+	}
+	if filter.Public != nil {
+		where("is_public = ?", *filter.Public)
+	}
+
+	condition := "TRUE"
+	if len(conditions) > 0 {
+		condition = strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM clients WHERE `+condition, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count clients: %w", err)
+	}
+
+	query := `
+        SELECT ` + clientColumns + `
+        FROM clients
+        WHERE ` + condition + `
+        ORDER BY created_at DESC, id
+        LIMIT $` + strconv.Itoa(len(args)+1) + ` OFFSET $` + strconv.Itoa(len(args)+2)
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list clients: %w", err)
+	}
+	defer rows.Close()
+
+	clients := make([]models.Client, 0)
+	for rows.Next() {
+		var client models.Client
+		if err := scanClient(rows, &client); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan client: %w", err)
+		}
+		clients = append(clients, client)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list clients: %w", err)
+	}
+	return clients, total, nil
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// UpdateClient сохраняет изменяемые администратором поля действующего клиента. Возвращает false,
+// если клиент не найден или удален
+func (s *PostgresStore) UpdateClient(ctx context.Context, client *models.Client) (bool, error) {
+	query := `
+        UPDATE clients SET
+            domain = $2, require_pkce = $3, userinfo_signed_response_alg = NULLIF($4, ''),
+            redirect_uris = COALESCE($5::TEXT[], '{}'), grant_types = COALESCE($6::TEXT[], '{}'),
+            response_types = COALESCE($7::TEXT[], '{}'), allowed_scopes = COALESCE($8::TEXT[], '{}')
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+	result, err := s.db.ExecContext(ctx, query,
+		client.ID, client.Domain, client.RequirePKCE, client.UserinfoSignedResponseAlg,
+		pq.Array(client.RedirectURIs), pq.Array(client.GrantTypes),
+		pq.Array(client.ResponseTypes), pq.Array(client.AllowedScopes),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update client: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// DeleteClient мягко удаляет клиента: строка остается для аудита, хеши секретов стираются.
+// Согласия пользователей и неиспользованные authorization codes удаляются в той же транзакции,
+// выданные клиенту токены отзываются. Возвращает false, если клиент не найден или уже удален
+func (s *PostgresStore) DeleteClient(ctx context.Context, clientID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE clients
+        SET deleted_at = NOW(), secret = '', previous_secret = NULL, previous_secret_expires_at = NULL
+        WHERE id = $1 AND deleted_at IS NULL
+    `
+	result, err := tx.ExecContext(ctx, query, clientID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete client: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_consents WHERE client_id = $1`, clientID); err != nil {
+		return false, fmt.Errorf("failed to delete consents: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM oauth2_authorization_codes WHERE client_id = $1`, clientID); err != nil {
+		return false, fmt.Errorf("failed to delete authorization codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if _, err := revokeTokensWhere(ctx, s.db, "client_id = $1", clientID); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// RotateClientSecret заменяет секрет конфиденциального клиента. Хеш прежнего секрета принимается
+// еще grace, при нулевом grace прежний секрет отзывается сразу. Возвращает время, до которого
+// принимается прежний секрет, и false, если клиент не найден, удален или публичный
+func (s *PostgresStore) RotateClientSecret(ctx context.Context, clientID, secret string, grace time.Duration) (*time.Time, bool, error) {
+	hash, err := s.secretHasher.Hash(secret)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to hash client secret: %w", err)
+	}
+
+	var previousExpiresAt sql.NullTime
+	if grace > 0 {
+		previousExpiresAt = sql.NullTime{Time: time.Now().Add(grace), Valid: true}
+	}
+
+	// При повторной ротации до истечения grace принимается только последний прежний секрет
+	query := `
+        UPDATE clients SET
+            previous_secret = CASE WHEN $3::TIMESTAMPTZ IS NULL THEN NULL ELSE secret END,
+            previous_secret_expires_at = $3,
+            secret = $2
+        WHERE id = $1 AND deleted_at IS NULL AND NOT is_public
+        RETURNING previous_secret_expires_at
+    `
+	var expiresAt *time.Time
+	if err := s.db.QueryRowContext(ctx, query, clientID, hash, previousExpiresAt).Scan(&expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to rotate client secret: %w", err)
+	}
+
+	return expiresAt, true, nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go_oauth2_server/internal/models"
+)
+
+// GetConsent получает согласие пользователя для клиента. Если согласия нет, возвращает nil, nil
+func (s *PostgresStore) GetConsent(ctx context.Context, userID, clientID string) (*models.Consent, error) {
+	query := `
+        SELECT user_id, client_id, scope, created_at, updated_at
+        FROM user_consents
+        WHERE user_id = $1 AND client_id = $2
+    `
+
+	consent := &models.Consent{}
+	err := s.db.QueryRowContext(ctx, query, userID, clientID).Scan(
+		&consent.UserID, &consent.ClientID, &consent.Scope, &consent.CreatedAt, &consent.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get consent: %w", err)
+	}
+	return consent, nil
+}
+
+// ListConsents получает все согласия пользователя
+func (s *PostgresStore) ListConsents(ctx context.Context, userID string) ([]models.Consent, error) {
+	query := `
+        SELECT user_id, client_id, scope, created_at, updated_at
+        FROM user_consents
+        WHERE user_id = $1
+        ORDER BY updated_at DESC
+    `
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consents: %w", err)
+	}
+	defer rows.Close()
+
+	consents := make([]models.Consent, 0)
+	for rows.Next() {
+		var consent models.Consent
+		if err := rows.Scan(&consent.UserID, &consent.ClientID, &consent.Scope, &consent.CreatedAt, &consent.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan consent: %w", err)
+		}
+		consents = append(consents, consent)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list consents: %w", err)
+	}
+	return consents, nil
+}
+
+// SaveConsent сохраняет согласие пользователя, заменяя список scope существующего согласия
+func (s *PostgresStore) SaveConsent(ctx context.Context, consent *models.Consent) error {
+	query := `
+        INSERT INTO user_consents (user_id, client_id, scope)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id, client_id) DO UPDATE SET scope = EXCLUDED.scope
+    `
+	if _, err := s.db.ExecContext(ctx, query, consent.UserID, consent.ClientID, consent.Scope); err != nil {
+		return fmt.Errorf("failed to save consent: %w", err)
+	}
+	return nil
+}
+
+// DeleteConsent отзывает согласие пользователя вместе с выданными клиенту кодами и токенами.
+// Возвращает false, если согласия не было.
+func (s *PostgresStore) DeleteConsent(ctx context.Context, userID, clientID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx,
+		`DELETE FROM user_consents WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete consent: %w", err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+
+	// Неиспользованные коды больше не должны обмениваться на токены
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM oauth2_authorization_codes WHERE user_id = $1 AND client_id = $2`, userID, clientID); err != nil {
+		return false, fmt.Errorf("failed to delete authorization codes: %w", err)
+	}
+
+	if _, err := revokeTokensWhere(ctx, s.db, "user_id = $1 AND client_id = $2", userID, clientID); err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go_oauth2_server/internal/models"
+)
+
+// Субъекты учета неудачных входов
+const (
+	LoginSubjectUser = "user"
+	LoginSubjectIP   = "ip"
+)
+
+// GetLoginFailures получает неудачные попытки входа субъекта за окно window. Ошибки старше окна
+// не учитываются, если субъект не заблокирован. Без таких попыток возвращает nil, nil
+func (s *PostgresStore) GetLoginFailures(ctx context.Context, subjectType, subject string, window time.Duration) (*models.LoginFailures, error) {
+	query := `
+        SELECT subject_type, subject, failures, last_failure_at, locked_until
+        FROM login_failures
+        WHERE subject_type = $1 AND subject = $2 AND (last_failure_at > $3 OR locked_until > $4)
+    `
+
+	now := time.Now()
+	failures, err := scanLoginFailures(s.db.QueryRowContext(ctx, query, subjectType, subject, now.Add(-window), now))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+	return failures, nil
+}
+
+// RecordLoginFailure учитывает неудачную попытку входа. Счетчик начинается заново после окна
+// без ошибок; при достижении maxFailures субъект блокируется на lockout
+func (s *PostgresStore) RecordLoginFailure(ctx context.Context, subjectType, subject string, window time.Duration, maxFailures int, lockout time.Duration) (*models.LoginFailures, error) {
+	query := `
+        INSERT INTO login_failures (subject_type, subject, failures, last_failure_at, locked_until)
+        VALUES ($1, $2, 1, $3, CASE WHEN $5 <= 1 THEN $6::TIMESTAMP WITH TIME ZONE END)
+        ON CONFLICT (subject_type, subject) DO UPDATE SET
+            failures = CASE WHEN login_failures.last_failure_at > $4 THEN login_failures.failures + 1 ELSE 1 END,
+            last_failure_at = EXCLUDED.last_failure_at,
+            locked_until = CASE
+                WHEN (CASE WHEN login_failures.last_failure_at > $4 THEN login_failures.failures + 1 ELSE 1 END) >= $5 THEN $6
+                ELSE login_failures.locked_until
+            END
+        RETURNING subject_type, subject, failures, last_failure_at, locked_until
+    `
+
+	now := time.Now()
+	failures, err := scanLoginFailures(s.db.QueryRowContext(ctx, query,
+		subjectType, subject, now, now.Add(-window), maxFailures, now.Add(lockout),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// ResetLoginFailures сбрасывает счетчик и блокировку субъекта. Возвращает false, если записи не было
+func (s *PostgresStore) ResetLoginFailures(ctx context.Context, subjectType, subject string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM login_failures WHERE subject_type = $1 AND subject = $2`, subjectType, subject)
+	if err != nil {
+		return false, fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// loginFailuresRetention срок хранения записей о неудачных входах без действующей блокировки
+const loginFailuresRetention = 24 * time.Hour
+
+// cleanExpiredLoginFailures удаляет устаревшие записи о неудачных входах
+func cleanExpiredLoginFailures(ctx context.Context, db *sql.DB) (int64, error) {
+	query := `
+        DELETE FROM login_failures
+        WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < NOW())
+    `
+
+	result, err := db.ExecContext(ctx, query, time.Now().Add(-loginFailuresRetention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired login failures: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
+
+// scanLoginFailures читает запись login_failures
+func scanLoginFailures(row *sql.Row) (*models.LoginFailures, error) {
+	failures := &models.LoginFailures{}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&failures.SubjectType, &failures.Subject, &failures.Failures, &failures.LastFailureAt, &lockedUntil); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		failures.LockedUntil = &lockedUntil.Time
+	}
+	return failures, nil
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"go_oauth2_server/internal/models"
+)
+
+// GetTOTP получает второй фактор пользователя. Если он не настроен, возвращает nil, nil
+func (s *PostgresStore) GetTOTP(ctx context.Context, userID string) (*models.TOTP, error) {
+	query := `
+        SELECT user_id, secret, confirmed_at, last_used_step, created_at
+        FROM user_totp
+        WHERE user_id = $1
+    `
+
+	totp := &models.TOTP{}
+	var confirmedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID, &totp.Secret, &confirmedAt, &totp.LastUsedStep, &totp.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get TOTP: %w", err)
+	}
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = &confirmedAt.Time
+	}
+	return totp, nil
+}
+
+// SaveTOTPEnrollment сохраняет зашифрованный секрет неподтвержденного второго фактора, заменяя
+// незавершенную настройку. Возвращает false, если второй фактор уже подтвержден
+func (s *PostgresStore) SaveTOTPEnrollment(ctx context.Context, userID, encryptedSecret string) (bool, error) {
+	query := `
+        INSERT INTO user_totp (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET
+            secret = EXCLUDED.secret,
+            last_used_step = 0,
+            created_at = NOW()
+        WHERE user_totp.confirmed_at IS NULL
+    `
+
+	result, err := s.db.ExecContext(ctx, query, userID, encryptedSecret)
+	if err != nil {
+		return false, fmt.Errorf("failed to save TOTP enrollment: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// ConfirmTOTP подтверждает второй фактор кодом шага step и сохраняет коды восстановления.
+// Возвращает false, если настройка не найдена или уже подтверждена
+func (s *PostgresStore) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryCodes []string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+        UPDATE user_totp
+        SET confirmed_at = NOW(), last_used_step = $2
+        WHERE user_id = $1 AND confirmed_at IS NULL
+    `
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to confirm TOTP: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// UseTOTPStep отмечает шаг step использованным. Возвращает false, если этот или более поздний шаг
+// уже использован: из двух одновременных входов с одним кодом успешным будет только один
+func (s *PostgresStore) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `
+        UPDATE user_totp
+        SET last_used_step = $2
+        WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2
+    `
+
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use TOTP step: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// DeleteTOTP отключает второй фактор и удаляет коды восстановления
+func (s *PostgresStore) DeleteTOTP(ctx context.Context, userID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete TOTP: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return false, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// ReplaceRecoveryCodes заменяет коды восстановления пользователя новым набором
+func (s *PostgresStore) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, codes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// replaceRecoveryCodes удаляет прежние коды восстановления и сохраняет хеши новых
+func (s *PostgresStore) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, codes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, code := range codes {
+		query := `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, s.tokenHasher.Hash(code)); err != nil {
+			return fmt.Errorf("failed to save recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode отмечает код восстановления использованным. Возвращает false для неизвестного
+// или уже использованного кода
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, userID, code string) (bool, error) {
+	query := `
+        UPDATE user_recovery_codes
+        SET used_at = NOW()
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `
+
+	result, err := s.db.ExecContext(ctx, query, userID, s.tokenHasher.Hash(code))
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// CountRecoveryCodes число неиспользованных кодов восстановления
+func (s *PostgresStore) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
@@ -3,38 +3,54 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"go_oauth2_server/internal/hasher"
 	"go_oauth2_server/internal/models"
 
 	"github.com/go-oauth2/oauth2/v4"
 	oauthModels "github.com/go-oauth2/oauth2/v4/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
+// emailIndex уникальный индекс адресов электронной почты без учета регистра
+const emailIndex = "idx_users_email_lower"
+
+// ErrEmailTaken адрес электронной почты уже принадлежит другому пользователю
+var ErrEmailTaken = errors.New("email is already registered")
+
 type PostgresStore struct {
-	db          *sql.DB
-	clientStore oauth2.ClientStore
-	tokenStore  oauth2.TokenStore
-	logger      *slog.Logger
+	db             *sql.DB
+	clientStore    oauth2.ClientStore
+	tokenStore     oauth2.TokenStore
+	logger         *slog.Logger
+	secretHasher   *hasher.Hasher
+	passwordHasher *hasher.Hasher
+	tokenHasher    *TokenHasher
 }
 
-func NewPostgresStore(db *sql.DB) *PostgresStore {
+func NewPostgresStore(db *sql.DB, secretHasher, passwordHasher *hasher.Hasher, tokenHasher *TokenHasher) *PostgresStore {
 	logger := slog.Default()
-	clientStore := &ClientStore{db: db, logger: logger}
+	clientStore := &ClientStore{db: db, logger: logger, secretHasher: secretHasher}
 	var tokenStore oauth2.TokenStore
 	if logger != nil { // TODO  Подумать о реализации. Пока так оставлю
-		tokenStore = NewProductionTokenStore(db, logger) // Продакшн
+		tokenStore = NewProductionTokenStore(db, logger, tokenHasher) // Продакшн
 	} else {
-		tokenStore = NewSimpleTokenStore(db) // Разработка
+		tokenStore = NewSimpleTokenStore(db, tokenHasher) // Разработка
 	}
 
 	return &PostgresStore{
-		db:          db,
-		clientStore: clientStore,
-		tokenStore:  tokenStore,
-		logger:      logger,
+		db:             db,
+		clientStore:    clientStore,
+		tokenStore:     tokenStore,
+		logger:         logger,
+		secretHasher:   secretHasher,
+		passwordHasher: passwordHasher,
+		tokenHasher:    tokenHasher,
 	}
 }
 
@@ -50,112 +66,272 @@ func (s *PostgresStore) Ping(ctx context.Context) error {
 	return s.db.PingContext(ctx)
 }
 
+// CreateClient сохраняет клиента. Секрет передается в открытом виде и сохраняется только как хеш
 func (s *PostgresStore) CreateClient(ctx context.Context, client *models.Client) error {
+	secretHash := ""
+	if client.Secret != "" {
+		hash, err := s.secretHasher.Hash(client.Secret)
+		if err != nil {
+			return err
+		}
+		secretHash = hash
+	}
+
 	query := `
-        INSERT INTO clients (id, secret, domain, user_id, created_at)
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO clients (
+            id, secret, domain, user_id, is_public, require_pkce, userinfo_signed_response_alg,
+            redirect_uris, grant_types, response_types, allowed_scopes, created_at
+        ) VALUES (
+            $1, $2, $3, $4, $5, $6, NULLIF($7, ''),
+            COALESCE($8::TEXT[], '{}'), COALESCE($9::TEXT[], '{}'), COALESCE($10::TEXT[], '{}'), COALESCE($11::TEXT[], '{}'), $12
+        )
     `
-	_, err := s.db.ExecContext(ctx, query, client.ID, client.Secret, client.Domain, client.UserID, client.CreatedAt)
+	_, err := s.db.ExecContext(ctx, query,
+		client.ID, secretHash, client.Domain, client.UserID, client.Public, client.RequirePKCE,
+		client.UserinfoSignedResponseAlg, pq.Array(client.RedirectURIs),
+		pq.Array(client.GrantTypes), pq.Array(client.ResponseTypes), pq.Array(client.AllowedScopes), client.CreatedAt,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create client: %w", err)
 	}
 
-	// Also add to OAuth2 client store
-	clientInfo := &oauthModels.Client{
-		ID:     client.ID,
-		Secret: client.Secret,
-		Domain: client.Domain,
-		UserID: client.UserID,
-	}
-
-	if cs, ok := s.clientStore.(*ClientStore); ok {
-		return cs.Set(ctx, client.ID, clientInfo)
-	}
-
 	return nil
 }
 
+// GetClient получает действующего клиента. Поле Secret содержит хеш секрета
 func (s *PostgresStore) GetClient(ctx context.Context, clientID string) (*models.Client, error) {
+	return s.getClient(ctx, "id = $1 AND deleted_at IS NULL", clientID)
+}
+
+// getClient получает клиента по условию
+func (s *PostgresStore) getClient(ctx context.Context, condition string, args ...interface{}) (*models.Client, error) {
 	client := &models.Client{}
 	query := `
-        SELECT id, secret, domain, user_id, created_at
+        SELECT ` + clientColumns + `
         FROM clients
-        WHERE id = $1
-    `
-	err := s.db.QueryRowContext(ctx, query, clientID).Scan(
-		&client.ID, &client.Secret, &client.Domain, &client.UserID, &client.CreatedAt,
-	)
-	if err != nil {
+        WHERE ` + condition
+	if err := scanClient(s.db.QueryRowContext(ctx, query, args...), client); err != nil {
 		return nil, fmt.Errorf("failed to get client: %w", err)
 	}
 	return client, nil
 }
 
+// clientColumns поля клиента в порядке scanClient
+const clientColumns = `id, secret, domain, COALESCE(user_id, ''), is_public, require_pkce,
+               COALESCE(userinfo_signed_response_alg, ''), redirect_uris, grant_types, response_types,
+               allowed_scopes, created_at, COALESCE(previous_secret, ''), previous_secret_expires_at,
+               COALESCE(updated_at, created_at), deleted_at`
+
+// scanClient читает клиента, выбранного с полями clientColumns
+func scanClient(row interface{ Scan(...interface{}) error }, client *models.Client) error {
+	return row.Scan(
+		&client.ID, &client.Secret, &client.Domain, &client.UserID, &client.Public, &client.RequirePKCE,
+		&client.UserinfoSignedResponseAlg, pq.Array(&client.RedirectURIs),
+		pq.Array(&client.GrantTypes), pq.Array(&client.ResponseTypes), pq.Array(&client.AllowedScopes), &client.CreatedAt,
+		&client.PreviousSecret, &client.PreviousSecretExpiresAt, &client.UpdatedAt, &client.DeletedAt,
+	)
+}
+
+// CreateUser сохраняет пользователя. Пароль передается в открытом виде и сохраняется только как хеш
 func (s *PostgresStore) CreateUser(ctx context.Context, user *models.User) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwordHasher.Hash(user.Password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
+	address, err := marshalAddress(user.Address)
+	if err != nil {
+		return err
+	}
+
 	query := `
-        INSERT INTO users (id, username, password, created_at)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO users (
+            id, username, password, created_at,
+            name, given_name, family_name, nickname, picture, locale, zoneinfo,
+            email, phone_number, address, updated_at
+        ) VALUES (
+            $1, $2, $3, $4,
+            NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''),
+            NULLIF($12, ''), NULLIF($13, ''), $14, $4
+        )
     `
-	_, err = s.db.ExecContext(ctx, query, user.ID, user.Username, string(hashedPassword), user.CreatedAt)
+	_, err = s.db.ExecContext(ctx, query,
+		user.ID, user.Username, hashedPassword, user.CreatedAt,
+		user.Name, user.GivenName, user.FamilyName, user.Nickname, user.Picture, user.Locale, user.Zoneinfo,
+		user.Email, user.PhoneNumber, address,
+	)
 	if err != nil {
+		if isUniqueViolation(err, emailIndex) {
+			return ErrEmailTaken
+		}
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 	return nil
 }
 
+// isUniqueViolation проверяет, что запрос нарушил уникальный индекс constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
 func (s *PostgresStore) GetUser(ctx context.Context, username string) (*models.User, error) {
-	user := &models.User{}
+	return s.getUserWhere(ctx, "username = $1", username)
+}
+
+// GetUserByEmail получает пользователя по адресу электронной почты без учета регистра.
+// Если пользователя нет, возвращает nil, nil
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.getUserWhere(ctx, "LOWER(email) = LOWER($1)", email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (s *PostgresStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	return s.getUserWhere(ctx, "id = $1", id)
+}
+
+// getUserWhere получает пользователя вместе с профилем по условию
+func (s *PostgresStore) getUserWhere(ctx context.Context, condition string, args ...interface{}) (*models.User, error) {
 	query := `
-        SELECT id, username, password, created_at
+        SELECT id, username, password, created_at,
+               COALESCE(name, ''), COALESCE(given_name, ''), COALESCE(family_name, ''),
+               COALESCE(nickname, ''), COALESCE(picture, ''), COALESCE(locale, ''), COALESCE(zoneinfo, ''),
+               COALESCE(email, ''), email_verified, COALESCE(phone_number, ''), phone_number_verified,
+               address, COALESCE(updated_at, created_at)
         FROM users
-        WHERE username = $1
-    `
-	err := s.db.QueryRowContext(ctx, query, username).Scan(
+        WHERE ` + condition
+
+	user := &models.User{}
+	var address []byte
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID, &user.Username, &user.Password, &user.CreatedAt,
+		&user.Name, &user.GivenName, &user.FamilyName,
+		&user.Nickname, &user.Picture, &user.Locale, &user.Zoneinfo,
+		&user.Email, &user.EmailVerified, &user.PhoneNumber, &user.PhoneNumberVerified,
+		&address, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+
+	if len(address) > 0 {
+		user.Address = &models.Address{}
+		if err := json.Unmarshal(address, user.Address); err != nil {
+			return nil, fmt.Errorf("failed to decode user address: %w", err)
+		}
+	}
 	return user, nil
 }
 
+// marshalAddress кодирует адрес в JSONB, пустой адрес сохраняется как NULL
+func marshalAddress(address *models.Address) (interface{}, error) {
+	if address == nil || *address == (models.Address{}) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user address: %w", err)
+	}
+	return string(data), nil
+}
+
+// ValidateUser проверяет пароль пользователя. Хеш, полученный другим алгоритмом или с другими
+// параметрами, после успешного входа пересчитывается текущими настройками
 func (s *PostgresStore) ValidateUser(ctx context.Context, username, password string) (*models.User, error) {
 	user, err := s.GetUser(ctx, username)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
+	if !s.passwordHasher.Verify(user.Password, password) {
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
+	if s.passwordHasher.NeedsRehash(user.Password) {
+		if err := s.rehashPassword(ctx, user, password); err != nil {
+			// Вход не прерывается: хеш будет пересчитан при следующем входе
+			s.logger.Error("Failed to rehash user password", "user_id", user.ID, "error", err)
+		}
+	}
+
 	return user, nil
 }
 
+// rehashPassword заменяет хеш пароля пользователя хешем с текущими настройками. Хеш заменяется,
+// только если не изменился с момента проверки, чтобы не затереть параллельную смену пароля
+func (s *PostgresStore) rehashPassword(ctx context.Context, user *models.User, password string) error {
+	hash, err := s.passwordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE users SET password = $1 WHERE id = $2 AND password = $3`
+	if _, err := s.db.ExecContext(ctx, query, hash, user.ID, user.Password); err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
+
+	s.logger.Info("User password rehashed", "user_id", user.ID, "algorithm", s.passwordHasher.Algorithm())
+	user.Password = hash
+	return nil
+}
+
 func (s *PostgresStore) ValidateClient(ctx context.Context, clientID, clientSecret string) (*models.Client, error) {
 	client, err := s.GetClient(ctx, clientID)
 	if err != nil {
 		return nil, err
 	}
 
-	if client.Secret != clientSecret {
+	if !verifyClientSecret(s.secretHasher, client.Secret, client.PreviousSecret, client.PreviousSecretExpiresAt, clientSecret) {
 		return nil, fmt.Errorf("invalid client credentials")
 	}
 
 	return client, nil
 }
 
-// CleanExpiredTokens очищает истекшие токены
+// CleanExpiredTokens удаляет истекшие токены, authorization codes, записи denylist, сессии,
+// счетчики неудачных входов, корзины лимитов, challenges WebAuthn и одноразовые ссылки.
+// Не зависит от реализации хранилища токенов
 func (s *PostgresStore) CleanExpiredTokens(ctx context.Context) error {
-	if tokenStore, ok := s.tokenStore.(*SimpleTokenStore); ok {
-		return tokenStore.CleanExpiredTokens(ctx)
+	query := `
+        DELETE FROM oauth2_tokens
+        WHERE access_expires_at < NOW()
+        AND (refresh_expires_at IS NULL OR refresh_expires_at < NOW())
+    `
+
+	start := time.Now()
+	result, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to clean expired tokens: %w", err)
 	}
+	rowsAffected, _ := result.RowsAffected()
+
+	cleaners := []struct {
+		name  string
+		clean func(context.Context, *sql.DB) (int64, error)
+	}{
+		{"codes_affected", cleanExpiredAuthorizationCodes},
+		{"revocations_affected", cleanExpiredRevocations},
+		{"sessions_affected", cleanExpiredSessions},
+		{"login_failures_affected", cleanExpiredLoginFailures},
+		{"rate_limits_affected", cleanExpiredRateLimits},
+		{"webauthn_challenges_affected", cleanExpiredWebAuthnChallenges},
+		{"user_tokens_affected", cleanExpiredUserTokens},
+	}
+
+	attrs := []interface{}{"rows_affected", rowsAffected}
+	for _, cleaner := range cleaners {
+		affected, err := cleaner.clean(ctx, s.db)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, cleaner.name, affected)
+	}
+
+	s.logger.Info("Expired tokens cleaned", append(attrs, "duration", time.Since(start))...)
 	return nil
 }
 
@@ -182,33 +358,56 @@ func (s *PostgresStore) GetTokenStats(ctx context.Context) (map[string]int64, er
 	}, nil
 }
 
-// ClientStore implements oauth2.ClientStore
+// ClientStore implements oauth2.ClientStore. Клиент читается из БД при каждом запросе без кеша,
+// чтобы удаление клиента и ротация секрета сразу действовали на всех репликах
 type ClientStore struct {
-	db      *sql.DB
-	clients map[string]oauth2.ClientInfo
-	logger  *slog.Logger
+	db           *sql.DB
+	logger       *slog.Logger
+	secretHasher *hasher.Hasher
 }
 
-func (cs *ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
-	// First check in-memory cache
-	if cs.clients != nil {
-		if client, exists := cs.clients[id]; exists {
-			if cs.logger != nil {
-				cs.logger.Debug("Client found in cache", "client_id", id)
-			}
-			return client, nil
-		}
+// clientInfo клиент для библиотеки OAuth2. Секрет хранится как хеш, поэтому проверяется
+// через oauth2.ClientPasswordVerifier, а не сравнением с GetSecret
+type clientInfo struct {
+	*oauthModels.Client
+	hasher *hasher.Hasher
+	// previousSecret хеш секрета до ротации, принимается до previousSecretExpiresAt
+	previousSecret          string
+	previousSecretExpiresAt *time.Time
+}
+
+// VerifyPassword проверяет секрет клиента по хешу. Публичные клиенты секрета не имеют
+func (c *clientInfo) VerifyPassword(secret string) bool {
+	if c.Public {
+		return true
+	}
+	return verifyClientSecret(c.hasher, c.Secret, c.previousSecret, c.previousSecretExpiresAt, secret)
+}
+
+// verifyClientSecret проверяет секрет по текущему хешу, а после ротации — и по хешу прежнего
+// секрета, пока не истек период, в течение которого он принимается
+func verifyClientSecret(h *hasher.Hasher, hash, previousHash string, previousExpiresAt *time.Time, secret string) bool {
+	if h.Verify(hash, secret) {
+		return true
 	}
+	return previousHash != "" && previousExpiresAt != nil && time.Now().Before(*previousExpiresAt) &&
+		h.Verify(previousHash, secret)
+}
 
-	// Query database
-	client := &oauthModels.Client{}
+func (cs *ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInfo, error) {
+	info := &oauthModels.Client{}
+	var redirectURIs []string
+	var previousSecret string
+	var previousSecretExpiresAt *time.Time
 	query := `
-        SELECT id, secret, domain, user_id
+        SELECT id, secret, COALESCE(user_id, ''), is_public, redirect_uris,
+               COALESCE(previous_secret, ''), previous_secret_expires_at
         FROM clients
-        WHERE id = $1
+        WHERE id = $1 AND deleted_at IS NULL
     `
 	err := cs.db.QueryRowContext(ctx, query, id).Scan(
-		&client.ID, &client.Secret, &client.Domain, &client.UserID,
+		&info.ID, &info.Secret, &info.UserID, &info.Public, pq.Array(&redirectURIs),
+		&previousSecret, &previousSecretExpiresAt,
 	)
 	if err != nil {
 		if cs.logger != nil {
@@ -217,22 +416,27 @@ func (cs *ClientStore) GetByID(ctx context.Context, id string) (oauth2.ClientInf
 		return nil, fmt.Errorf("failed to get client by ID: %w", err)
 	}
 
+	info.Domain = defaultRedirectURI(redirectURIs)
+
 	if cs.logger != nil {
 		cs.logger.Debug("Client retrieved from database", "client_id", id)
 	}
 
-	return client, nil
+	return &clientInfo{
+		Client:                  info,
+		hasher:                  cs.secretHasher,
+		previousSecret:          previousSecret,
+		previousSecretExpiresAt: previousSecretExpiresAt,
+	}, nil
 }
 
-func (cs *ClientStore) Set(ctx context.Context, id string, client oauth2.ClientInfo) error {
-	if cs.clients == nil {
-		cs.clients = make(map[string]oauth2.ClientInfo)
+// defaultRedirectURI redirect URI по умолчанию для запроса авторизации без redirect_uri.
+// Библиотека OAuth2 использует для этого домен клиента, поэтому в ClientInfo вместо домена
+// передается единственный зарегистрированный redirect URI. Сам redirect URI проверяется
+// обработчиком /authorize до передачи запроса библиотеке.
+func defaultRedirectURI(redirectURIs []string) string {
+	if len(redirectURIs) == 1 {
+		return redirectURIs[0]
 	}
-	cs.clients[id] = client
-
-	if cs.logger != nil {
-		cs.logger.Debug("Client cached", "client_id", id)
-	}
-
-	return nil
+	return ""
 }
@@ -8,35 +8,43 @@ import (
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/models"
 )
 
 // ProductionTokenStore - продакшн версия token store для PostgreSQL
 type ProductionTokenStore struct {
-	db     *sql.DB
-	logger *slog.Logger
+	db          *sql.DB
+	logger      *slog.Logger
+	tokenHasher *TokenHasher
 }
 
 // NewProductionTokenStore создает новый продакшн PostgreSQL token store
-func NewProductionTokenStore(db *sql.DB, logger *slog.Logger) *ProductionTokenStore {
+func NewProductionTokenStore(db *sql.DB, logger *slog.Logger, tokenHasher *TokenHasher) *ProductionTokenStore {
 	return &ProductionTokenStore{
-		db:     db,
-		logger: logger,
+		db:          db,
+		logger:      logger,
+		tokenHasher: tokenHasher,
 	}
 }
 
 // Create создает новый токен с детальным логированием
 func (ts *ProductionTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if info.GetCode() != "" {
+		return ts.createCode(ctx, info)
+	}
+
 	query := `
         INSERT INTO oauth2_tokens (
-            access_token, refresh_token, client_id, user_id, scope,
-            access_expires_at, refresh_expires_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
-        ON CONFLICT (access_token) DO UPDATE SET
-            refresh_token = EXCLUDED.refresh_token,
+            access_token_hash, refresh_token_hash, client_id, user_id, scope,
+            access_expires_at, refresh_expires_at, authorization_code, jti, auth_time, family_id, amr
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE($11::UUID, uuid_generate_v4()), $12)
+        ON CONFLICT (access_token_hash) DO UPDATE SET
+            refresh_token_hash = EXCLUDED.refresh_token_hash,
             scope = EXCLUDED.scope,
             access_expires_at = EXCLUDED.access_expires_at,
             refresh_expires_at = EXCLUDED.refresh_expires_at,
+            jti = EXCLUDED.jti,
             updated_at = NOW()
     `
 
@@ -53,13 +61,18 @@ func (ts *ProductionTokenStore) Create(ctx context.Context, info oauth2.TokenInf
 	defer cancel()
 
 	_, err := ts.db.ExecContext(ctx, query,
-		info.GetAccess(),
-		info.GetRefresh(),
+		ts.tokenHasher.Hash(info.GetAccess()),
+		ts.tokenHasher.nullHash(info.GetRefresh()),
 		info.GetClientID(),
 		info.GetUserID(),
 		info.GetScope(),
 		accessExpiresAt,
 		refreshExpiresAt,
+		tokenExtensionValue(info, extAuthorizationCode),
+		tokenExtensionValue(info, extJTI),
+		tokenExtensionTime(info, extAuthTime),
+		tokenExtensionValue(info, extFamilyID),
+		tokenExtensionValue(info, extAMR),
 	)
 
 	if err != nil {
@@ -80,32 +93,37 @@ func (ts *ProductionTokenStore) Create(ctx context.Context, info oauth2.TokenInf
 	return nil
 }
 
-// GetByAccess получает токен по access token с кешированием
+// GetByAccess получает токен по хешу access token. В БД нет исходного refresh токена,
+// поэтому в результате заполнен только access
 func (ts *ProductionTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
 	query := `
-        SELECT access_token, refresh_token, client_id, user_id, scope,
-               access_expires_at, refresh_expires_at, created_at
+        SELECT client_id, user_id, scope,
+               access_expires_at, refresh_expires_at, created_at, authorization_code, jti, auth_time, amr
         FROM oauth2_tokens 
-        WHERE access_token = $1 AND access_expires_at > NOW()
+        WHERE access_token_hash = $1 AND access_expires_at > NOW()
     `
 
 	// Добавляем таймаут для запроса
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	var accessToken, refreshToken, clientID, userID, scope string
+	var clientID, userID, scope string
 	var accessExpiresAt, createdAt time.Time
 	var refreshExpiresAt sql.NullTime
+	var authorizationCode, jti, amr sql.NullString
+	var authTime sql.NullTime
 
-	err := ts.db.QueryRowContext(ctx, query, access).Scan(
-		&accessToken,
-		&refreshToken,
+	err := ts.db.QueryRowContext(ctx, query, ts.tokenHasher.Hash(access)).Scan(
 		&clientID,
 		&userID,
 		&scope,
 		&accessExpiresAt,
 		&refreshExpiresAt,
 		&createdAt,
+		&authorizationCode,
+		&jti,
+		&authTime,
+		&amr,
 	)
 
 	if err != nil {
@@ -121,11 +139,11 @@ func (ts *ProductionTokenStore) GetByAccess(ctx context.Context, access string)
 	token := &models.Token{
 		ClientID:        clientID,
 		UserID:          userID,
-		Access:          accessToken,
-		Refresh:         refreshToken,
+		Access:          access,
 		Scope:           scope,
 		AccessCreateAt:  createdAt,
 		AccessExpiresIn: accessExpiresAt.Sub(createdAt),
+		Extension:       tokenExtension(authorizationCode, jti, authTime, amr),
 	}
 
 	if refreshExpiresAt.Valid {
@@ -141,68 +159,41 @@ func (ts *ProductionTokenStore) GetByAccess(ctx context.Context, access string)
 	return token, nil
 }
 
-// GetByRefresh получает токен по refresh token
+// GetByRefresh получает токен по хешу refresh token. Исходный access токен не хранится,
+// поэтому в результате заполнен только refresh. Токен, уже замененный при ротации, не возвращается.
+// Метод вызывается и интроспекцией, поэтому повторное предъявление здесь не обрабатывается:
+// семейство отзывает PostgresStore.RevokeReusedRefreshToken в обработчике refresh grant
 func (ts *ProductionTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
-	query := `
-        SELECT access_token, refresh_token, client_id, user_id, scope,
-               access_expires_at, refresh_expires_at, created_at
-        FROM oauth2_tokens 
-        WHERE refresh_token = $1 AND (refresh_expires_at IS NULL OR refresh_expires_at > NOW())
-    `
-
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	var accessToken, refreshToken, clientID, userID, scope string
-	var accessExpiresAt, createdAt time.Time
-	var refreshExpiresAt sql.NullTime
-
-	err := ts.db.QueryRowContext(ctx, query, refresh).Scan(
-		&accessToken,
-		&refreshToken,
-		&clientID,
-		&userID,
-		&scope,
-		&accessExpiresAt,
-		&refreshExpiresAt,
-		&createdAt,
-	)
-
+	rt, err := getRefreshToken(ctx, ts.db, ts.tokenHasher.Hash(refresh))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			ts.logger.Debug("Refresh token not found or expired")
-			return nil, nil
-		}
 		ts.logger.Error("Failed to get token by refresh", "error", err)
-		return nil, fmt.Errorf("failed to get token by refresh: %w", err)
+		return nil, err
 	}
-
-	token := &models.Token{
-		ClientID:        clientID,
-		UserID:          userID,
-		Access:          accessToken,
-		Refresh:         refreshToken,
-		Scope:           scope,
-		AccessCreateAt:  createdAt,
-		AccessExpiresIn: accessExpiresAt.Sub(createdAt),
+	if rt == nil {
+		ts.logger.Debug("Refresh token not found or expired")
+		return nil, nil
 	}
 
-	if refreshExpiresAt.Valid {
-		token.RefreshCreateAt = createdAt
-		token.RefreshExpiresIn = refreshExpiresAt.Time.Sub(createdAt)
+	if rt.used {
+		ts.logger.Debug("Refresh token already rotated", "client_id", rt.token.ClientID)
+		return nil, nil
 	}
 
-	return token, nil
+	rt.token.Refresh = refresh
+	return rt.token, nil
 }
 
-// RemoveByAccess удаляет токен по access token
+// RemoveByAccess удаляет токен по хешу access token
 func (ts *ProductionTokenStore) RemoveByAccess(ctx context.Context, access string) error {
-	query := `DELETE FROM oauth2_tokens WHERE access_token = $1`
+	query := `DELETE FROM oauth2_tokens WHERE access_token_hash = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	result, err := ts.db.ExecContext(ctx, query, access)
+	result, err := ts.db.ExecContext(ctx, query, ts.tokenHasher.Hash(access))
 	if err != nil {
 		ts.logger.Error("Failed to remove token by access", "error", err)
 		return fmt.Errorf("failed to remove token by access: %w", err)
@@ -216,36 +207,129 @@ func (ts *ProductionTokenStore) RemoveByAccess(ctx context.Context, access strin
 	return nil
 }
 
-// RemoveByRefresh удаляет токен по refresh token
+// RemoveByRefresh помечает refresh токен использованным. Библиотека вызывает его при ротации
+// после сохранения нового токена; запись остается до истечения, чтобы распознать повторное предъявление.
 func (ts *ProductionTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
-	query := `DELETE FROM oauth2_tokens WHERE refresh_token = $1`
-
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	result, err := ts.db.ExecContext(ctx, query, refresh)
+	refreshHash := ts.tokenHasher.Hash(refresh)
+	consumed, err := consumeRefreshToken(ctx, ts.db, refreshHash)
 	if err != nil {
-		ts.logger.Error("Failed to remove token by refresh", "error", err)
-		return fmt.Errorf("failed to remove token by refresh: %w", err)
+		ts.logger.Error("Failed to consume refresh token", "error", err)
+		return err
+	}
+	if consumed {
+		return nil
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected > 0 {
-		ts.logger.Info("Token removed by refresh", "rows_affected", rowsAffected)
+	// Токен уже использован параллельным запросом: семейство отзывается вместе с только что выданным токеном
+	rt, err := getRefreshToken(ctx, ts.db, refreshHash)
+	if err != nil {
+		ts.logger.Error("Failed to get token by refresh", "error", err)
+		return err
+	}
+	if rt != nil && rt.used {
+		if err := ts.revokeReusedFamily(ctx, rt); err != nil {
+			return err
+		}
+	}
+	return oauthErrors.ErrInvalidGrant
+}
+
+// revokeReusedFamily отзывает семейство токенов, refresh токен которого предъявлен повторно.
+// Это признак кражи токена: неизвестно, у кого из двух сторон действующий токен.
+func (ts *ProductionTokenStore) revokeReusedFamily(ctx context.Context, rt *refreshToken) error {
+	revoked, err := revokeTokenFamily(ctx, ts.db, rt.familyID)
+	if err != nil {
+		ts.logger.Error("Failed to revoke token family for reused refresh token",
+			"client_id", rt.token.ClientID,
+			"family_id", rt.familyID,
+			"error", err,
+		)
+		return err
 	}
 
+	ts.logger.Warn("Refresh token reuse detected, token family revoked",
+		"event", "refresh_token_reuse",
+		"client_id", rt.token.ClientID,
+		"user_id", rt.token.UserID,
+		"family_id", rt.familyID,
+		"rows_affected", revoked,
+	)
 	return nil
 }
 
-// RemoveByCode удаляет токен по authorization code
+// createCode сохраняет authorization code
+func (ts *ProductionTokenStore) createCode(ctx context.Context, info oauth2.TokenInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := createAuthorizationCode(ctx, ts.db, info); err != nil {
+		ts.logger.Error("Failed to create authorization code",
+			"client_id", info.GetClientID(),
+			"user_id", info.GetUserID(),
+			"error", err,
+		)
+		return err
+	}
+
+	ts.logger.Info("Authorization code created",
+		"client_id", info.GetClientID(),
+		"user_id", info.GetUserID(),
+	)
+
+	return nil
+}
+
+// RemoveByCode помечает authorization code использованным.
+// Код не удаляется, чтобы повторное предъявление можно было распознать в GetByCode.
 func (ts *ProductionTokenStore) RemoveByCode(ctx context.Context, code string) error {
-	// В нашей реализации authorization codes хранятся отдельно
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := consumeAuthorizationCode(ctx, ts.db, code); err != nil {
+		ts.logger.Warn("Failed to consume authorization code", "error", err)
+		return err
+	}
+
 	return nil
 }
 
-// GetByCode получает токен по authorization code
+// GetByCode получает токен по authorization code.
+// При повторном предъявлении уже использованного кода отзывает выпущенные по нему токены.
 func (ts *ProductionTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
-	return nil, nil
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ac, err := getAuthorizationCode(ctx, ts.db, code)
+	if err != nil {
+		ts.logger.Error("Failed to get authorization code", "error", err)
+		return nil, err
+	}
+	if ac == nil {
+		ts.logger.Debug("Authorization code not found or expired")
+		return nil, nil
+	}
+
+	if ac.consumed {
+		revoked, err := revokeTokensByAuthorizationCode(ctx, ts.db, code)
+		if err != nil {
+			ts.logger.Error("Failed to revoke tokens for replayed authorization code",
+				"client_id", ac.token.ClientID,
+				"error", err,
+			)
+			return nil, err
+		}
+		ts.logger.Warn("Authorization code replay detected, issued tokens revoked",
+			"client_id", ac.token.ClientID,
+			"user_id", ac.token.UserID,
+			"rows_affected", revoked,
+		)
+		return nil, nil
+	}
+
+	return ac.token, nil
 }
 
 // CleanExpiredTokens очищает истекшие токены с детальной статистикой
@@ -284,7 +368,7 @@ func (ts *ProductionTokenStore) GetTokenStats(ctx context.Context) (map[string]i
             COUNT(*) as total_tokens,
             COUNT(CASE WHEN access_expires_at > NOW() THEN 1 END) as active_tokens,
             COUNT(CASE WHEN access_expires_at <= NOW() THEN 1 END) as expired_tokens,
-            COUNT(CASE WHEN refresh_token IS NOT NULL THEN 1 END) as with_refresh
+            COUNT(CASE WHEN refresh_token_hash IS NOT NULL AND refresh_used_at IS NULL THEN 1 END) as with_refresh
         FROM oauth2_tokens
     `
 
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// rateLimitsRetention срок хранения корзин без запросов. За это время корзина при любых
+// разумных лимитах заполняется и не отличается от новой
+const rateLimitsRetention = time.Hour
+
+// TakeRateLimitToken пополняет корзину key по времени с последнего запроса и списывает из нее
+// запрос, если в ней есть целый токен. Все выражения SET читают прежнее состояние строки,
+// поэтому проверка и списание выполняются одним атомарным запросом
+func (s *PostgresStore) TakeRateLimitToken(ctx context.Context, key string, perSecond float64, capacity float64) (bool, float64, error) {
+	query := `
+        INSERT INTO rate_limits (key, tokens, allowed, updated_at)
+        VALUES ($1, $3::DOUBLE PRECISION - 1, TRUE, NOW())
+        ON CONFLICT (key) DO UPDATE SET
+            allowed = LEAST($3::DOUBLE PRECISION,
+                rate_limits.tokens + EXTRACT(EPOCH FROM NOW() - rate_limits.updated_at)::DOUBLE PRECISION * $2::DOUBLE PRECISION) >= 1,
+            tokens = LEAST($3::DOUBLE PRECISION,
+                rate_limits.tokens + EXTRACT(EPOCH FROM NOW() - rate_limits.updated_at)::DOUBLE PRECISION * $2::DOUBLE PRECISION)
+                - CASE WHEN LEAST($3::DOUBLE PRECISION,
+                    rate_limits.tokens + EXTRACT(EPOCH FROM NOW() - rate_limits.updated_at)::DOUBLE PRECISION * $2::DOUBLE PRECISION) >= 1
+                THEN 1 ELSE 0 END,
+            updated_at = NOW()
+        RETURNING allowed, tokens
+    `
+
+	var allowed bool
+	var tokens float64
+	if err := s.db.QueryRowContext(ctx, query, key, perSecond, capacity).Scan(&allowed, &tokens); err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	return allowed, tokens, nil
+}
+
+// cleanExpiredRateLimits удаляет корзины без запросов дольше rateLimitsRetention
+func cleanExpiredRateLimits(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, time.Now().Add(-rateLimitsRetention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired rate limits: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
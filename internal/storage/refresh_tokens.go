@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go_oauth2_server/internal/jwt"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// extFamilyID ключ расширения с семейством refresh токена. Менеджер OAuth2 при обновлении
+// сохраняет новый токен с расширениями старого, поэтому семейство переходит к новому токену
+const extFamilyID = jwt.ExtensionFamilyID
+
+// refreshToken запись oauth2_tokens, найденная по хешу refresh токена
+type refreshToken struct {
+	token    *models.Token
+	familyID string
+	used     bool
+}
+
+// getRefreshToken получает токен по хешу refresh токена, включая уже использованные при ротации
+func getRefreshToken(ctx context.Context, db *sql.DB, refreshHash string) (*refreshToken, error) {
+	query := `
+        SELECT client_id, user_id, scope,
+               access_expires_at, refresh_expires_at, created_at, authorization_code, jti, auth_time, amr,
+               family_id, refresh_used_at IS NOT NULL
+        FROM oauth2_tokens
+        WHERE refresh_token_hash = $1 AND (refresh_expires_at IS NULL OR refresh_expires_at > NOW())
+    `
+
+	var (
+		clientID, userID, scope string
+		accessExpiresAt         time.Time
+		createdAt               time.Time
+		refreshExpiresAt        sql.NullTime
+		authorizationCode, jti  sql.NullString
+		amr                     sql.NullString
+		authTime                sql.NullTime
+		familyID                string
+		used                    bool
+	)
+
+	err := db.QueryRowContext(ctx, query, refreshHash).Scan(
+		&clientID,
+		&userID,
+		&scope,
+		&accessExpiresAt,
+		&refreshExpiresAt,
+		&createdAt,
+		&authorizationCode,
+		&jti,
+		&authTime,
+		&amr,
+		&familyID,
+		&used,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get token by refresh: %w", err)
+	}
+
+	token := &models.Token{
+		ClientID:        clientID,
+		UserID:          userID,
+		Scope:           scope,
+		AccessCreateAt:  createdAt,
+		AccessExpiresIn: accessExpiresAt.Sub(createdAt),
+		Extension:       tokenExtension(authorizationCode, jti, authTime, amr),
+	}
+	token.Extension.Set(extFamilyID, familyID)
+
+	if refreshExpiresAt.Valid {
+		token.RefreshCreateAt = createdAt
+		token.RefreshExpiresIn = refreshExpiresAt.Time.Sub(createdAt)
+	}
+
+	return &refreshToken{token: token, familyID: familyID, used: used}, nil
+}
+
+// consumeRefreshToken помечает refresh токен использованным после выдачи нового токена семейства
+// и в той же транзакции отзывает access токен, выданный вместе с ним. Библиотека при ротации
+// удаляет прежний access токен через RemoveByAccess, но GetByRefresh не знает его значения,
+// поэтому отзыв выполняется здесь по записи refresh токена. Повторная пометка невозможна,
+// поэтому из двух одновременных обновлений одним токеном успешным будет только одно.
+func consumeRefreshToken(ctx context.Context, db *sql.DB, refreshHash string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `
+        SELECT jti, access_expires_at
+        FROM oauth2_tokens
+        WHERE refresh_token_hash = $1 AND refresh_used_at IS NULL
+        FOR UPDATE
+    `
+	var jti sql.NullString
+	var accessExpiresAt time.Time
+	if err := tx.QueryRowContext(ctx, query, refreshHash).Scan(&jti, &accessExpiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	query = `
+        UPDATE oauth2_tokens
+        SET refresh_used_at = NOW(), access_expires_at = LEAST(access_expires_at, NOW())
+        WHERE refresh_token_hash = $1
+    `
+	if _, err := tx.ExecContext(ctx, query, refreshHash); err != nil {
+		return false, fmt.Errorf("failed to consume refresh token: %w", err)
+	}
+
+	// JWT самодостаточен, поэтому прежний access токен вносится в denylist до своего истечения
+	if jti.Valid && accessExpiresAt.After(time.Now()) {
+		query = `
+            INSERT INTO revoked_tokens (jti, expires_at)
+            VALUES ($1, $2)
+            ON CONFLICT (jti) DO NOTHING
+        `
+		if _, err := tx.ExecContext(ctx, query, jti.String, accessExpiresAt); err != nil {
+			return false, fmt.Errorf("failed to deny previous access token: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil
+}
+
+// revokeTokenFamily отзывает все токены семейства, выпущенные из одного исходного гранта
+func revokeTokenFamily(ctx context.Context, db *sql.DB, familyID string) (int64, error) {
+	return revokeTokensWhere(ctx, db, "family_id = $1", familyID)
+}
+
+// RevokeReusedRefreshToken отзывает семейство токенов, если клиент предъявил refresh токен, уже
+// замененный при ротации. Это признак кражи: неизвестно, у кого из двух сторон действующий токен.
+// Вызывается только для refresh grant, чтобы интроспекция старого токена не отзывала семейство.
+// Возвращает true, если семейство отозвано
+func (s *PostgresStore) RevokeReusedRefreshToken(ctx context.Context, clientID, refresh string) (bool, error) {
+	rt, err := getRefreshToken(ctx, s.db, s.tokenHasher.Hash(refresh))
+	if err != nil || rt == nil || !rt.used || rt.token.ClientID != clientID {
+		return false, err
+	}
+
+	revoked, err := revokeTokenFamily(ctx, s.db, rt.familyID)
+	if err != nil {
+		return false, err
+	}
+
+	s.logger.Warn("Refresh token reuse detected, token family revoked",
+		"event", "refresh_token_reuse",
+		"client_id", rt.token.ClientID,
+		"user_id", rt.token.UserID,
+		"family_id", rt.familyID,
+		"rows_affected", revoked,
+	)
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// revokeTokensWhere вносит access токены в denylist и удаляет записи oauth2_tokens,
+// подходящие под условие. JWT самодостаточны, поэтому удаления записи недостаточно:
+// validateJWTToken проверяет jti по таблице revoked_tokens.
+func revokeTokensWhere(ctx context.Context, db *sql.DB, condition string, args ...interface{}) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	denyQuery := `
+        INSERT INTO revoked_tokens (jti, expires_at)
+        SELECT jti, access_expires_at FROM oauth2_tokens
+        WHERE jti IS NOT NULL AND ` + condition + `
+        ON CONFLICT (jti) DO NOTHING
+    `
+	if _, err := tx.ExecContext(ctx, denyQuery, args...); err != nil {
+		return 0, fmt.Errorf("failed to deny tokens: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM oauth2_tokens WHERE `+condition, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit token revocation: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
+
+// cleanExpiredRevocations удаляет из denylist записи об уже истекших токенах
+func cleanExpiredRevocations(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired revocations: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
+
+// RevokeJTI вносит JWT в denylist до момента его истечения
+func (s *PostgresStore) RevokeJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := `
+        INSERT INTO revoked_tokens (jti, expires_at)
+        VALUES ($1, $2)
+        ON CONFLICT (jti) DO NOTHING
+    `
+	if _, err := s.db.ExecContext(ctx, query, jti, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke jti: %w", err)
+	}
+	return nil
+}
+
+// IsJTIRevoked проверяет, находится ли JWT в denylist
+func (s *PostgresStore) IsJTIRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+	if err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("failed to check revoked jti: %w", err)
+	}
+	return revoked, nil
+}
+
+// RevokeAccessToken отзывает access токен, выпущенный клиенту
+func (s *PostgresStore) RevokeAccessToken(ctx context.Context, clientID, access string) (int64, error) {
+	return revokeTokensWhere(ctx, s.db, "access_token_hash = $1 AND client_id = $2", s.tokenHasher.Hash(access), clientID)
+}
+
+// RevokeRefreshToken отзывает refresh токен клиента вместе со всем семейством: предыдущими
+// и выпущенными по нему access токенами
+func (s *PostgresStore) RevokeRefreshToken(ctx context.Context, clientID, refresh string) (int64, error) {
+	condition := `family_id IN (
+            SELECT family_id FROM oauth2_tokens WHERE refresh_token_hash = $1 AND client_id = $2
+        )`
+	return revokeTokensWhere(ctx, s.db, condition, s.tokenHasher.Hash(refresh), clientID)
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"go_oauth2_server/internal/models"
+)
+
+// ListScopes возвращает все зарегистрированные scope
+func (s *PostgresStore) ListScopes(ctx context.Context) ([]models.Scope, error) {
+	query := `
+        SELECT name, description, is_default, restricted, created_at
+        FROM scopes
+        ORDER BY name
+    `
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scopes: %w", err)
+	}
+	defer rows.Close()
+
+	var scopes []models.Scope
+	for rows.Next() {
+		var scope models.Scope
+		if err := rows.Scan(&scope.Name, &scope.Description, &scope.Default, &scope.Restricted, &scope.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan scope: %w", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list scopes: %w", err)
+	}
+	return scopes, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go_oauth2_server/internal/models"
+)
+
+// CreateSession сохраняет сессию пользователя
+func (s *PostgresStore) CreateSession(ctx context.Context, session *models.Session) error {
+	query := `
+        INSERT INTO sessions (id, user_id, auth_time, expires_at, ip_address, user_agent, created_at, amr)
+        VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+    `
+	_, err := s.db.ExecContext(ctx, query,
+		session.ID, session.UserID, session.AuthTime, session.ExpiresAt,
+		session.IPAddress, session.UserAgent, session.CreatedAt, strings.Join(session.AMR, " "),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// GetSession получает действующую сессию. Для истекшей или отсутствующей сессии возвращает nil, nil
+func (s *PostgresStore) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	query := `
+        SELECT id, user_id, auth_time, expires_at, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at, amr
+        FROM sessions
+        WHERE id = $1 AND expires_at > NOW()
+    `
+
+	session := &models.Session{}
+	var amr string
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID, &session.UserID, &session.AuthTime, &session.ExpiresAt,
+		&session.IPAddress, &session.UserAgent, &session.CreatedAt, &amr,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	session.AMR = strings.Fields(amr)
+	return session, nil
+}
+
+// DeleteSession завершает сессию
+func (s *PostgresStore) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// cleanExpiredSessions удаляет истекшие сессии
+func cleanExpiredSessions(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired sessions: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go_oauth2_server/internal/jwt"
+	"go_oauth2_server/internal/models"
+)
+
+// ListSigningKeys возвращает активный ключ и ключи, еще не вышедшие из окна проверки
+func (s *PostgresStore) ListSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	query := `
+        SELECT kid, algorithm, private_key, created_at, retired_at, retire_after
+        FROM signing_keys
+        WHERE retire_after IS NULL OR retire_after > NOW()
+        ORDER BY created_at DESC
+    `
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var retiredAt, retireAfter sql.NullTime
+		if err := rows.Scan(&key.KID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &retiredAt, &retireAfter); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		if retireAfter.Valid {
+			key.RetireAfter = &retireAfter.Time
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RotateSigningKey выводит текущий активный ключ из подписи и сохраняет новый.
+// Если активный ключ уже сменился (ротацию выполнила другая реплика), возвращает jwt.ErrRotationConflict.
+func (s *PostgresStore) RotateSigningKey(ctx context.Context, currentKID string, key models.SigningKey, retireAfter time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if currentKID != "" {
+		result, err := tx.ExecContext(ctx, `
+            UPDATE signing_keys
+            SET retired_at = NOW(), retire_after = $2
+            WHERE kid = $1 AND retired_at IS NULL
+        `, currentKID, retireAfter)
+		if err != nil {
+			return fmt.Errorf("failed to retire signing key: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return jwt.ErrRotationConflict
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO signing_keys (kid, algorithm, private_key, created_at)
+        VALUES ($1, $2, $3, $4)
+    `, key.KID, key.Algorithm, key.PrivateKey, key.CreatedAt)
+	if err != nil {
+		// Уникальный индекс допускает только один активный ключ
+		return fmt.Errorf("%w: %v", jwt.ErrRotationConflict, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSigningKeys удаляет ключи, окно проверки которых закончилось
+func (s *PostgresStore) DeleteExpiredSigningKeys(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM signing_keys WHERE retire_after IS NOT NULL AND retire_after <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired signing keys: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected, nil
+}
+
+// EncryptStoredSigningKeys шифрует приватные ключи, сохраненные в открытом виде PEM
+// до появления шифрования ключей подписи. Возвращает число зашифрованных ключей
+func (s *PostgresStore) EncryptStoredSigningKeys(ctx context.Context, cipher jwt.KeyCipher) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Шифротекст в base64 не содержит дефисов, поэтому открытый PEM отличается по префиксу
+	rows, err := tx.QueryContext(ctx, `SELECT kid, private_key FROM signing_keys WHERE private_key LIKE '-----BEGIN%' FOR UPDATE`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list plaintext signing keys: %w", err)
+	}
+
+	encrypted := make(map[string]string)
+	for rows.Next() {
+		var kid, privateKey string
+		if err := rows.Scan(&kid, &privateKey); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		ciphertext, err := cipher.Encrypt(privateKey)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to encrypt signing key %s: %w", kid, err)
+		}
+		encrypted[kid] = ciphertext
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list plaintext signing keys: %w", err)
+	}
+
+	for kid, ciphertext := range encrypted {
+		if _, err := tx.ExecContext(ctx, `UPDATE signing_keys SET private_key = $2 WHERE kid = $1`, kid, ciphertext); err != nil {
+			return 0, fmt.Errorf("failed to store encrypted signing key %s: %w", kid, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(encrypted)), nil
+}
@@ -7,31 +7,38 @@ import (
 	"time"
 
 	"github.com/go-oauth2/oauth2/v4"
+	oauthErrors "github.com/go-oauth2/oauth2/v4/errors"
 	"github.com/go-oauth2/oauth2/v4/models"
 )
 
 // SimpleTokenStore - упрощенная версия token store для PostgreSQL
 type SimpleTokenStore struct {
-	db *sql.DB
+	db          *sql.DB
+	tokenHasher *TokenHasher
 }
 
 // NewSimpleTokenStore создает новый простой PostgreSQL token store
-func NewSimpleTokenStore(db *sql.DB) *SimpleTokenStore {
-	return &SimpleTokenStore{db: db}
+func NewSimpleTokenStore(db *sql.DB, tokenHasher *TokenHasher) *SimpleTokenStore {
+	return &SimpleTokenStore{db: db, tokenHasher: tokenHasher}
 }
 
 // Create создает новый токен
 func (ts *SimpleTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if info.GetCode() != "" {
+		return createAuthorizationCode(ctx, ts.db, info)
+	}
+
 	query := `
         INSERT INTO oauth2_tokens (
-            access_token, refresh_token, client_id, user_id, scope,
-            access_expires_at, refresh_expires_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
-        ON CONFLICT (access_token) DO UPDATE SET
-            refresh_token = EXCLUDED.refresh_token,
+            access_token_hash, refresh_token_hash, client_id, user_id, scope,
+            access_expires_at, refresh_expires_at, authorization_code, jti, auth_time, family_id, amr
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, COALESCE($11::UUID, uuid_generate_v4()), $12)
+        ON CONFLICT (access_token_hash) DO UPDATE SET
+            refresh_token_hash = EXCLUDED.refresh_token_hash,
             scope = EXCLUDED.scope,
             access_expires_at = EXCLUDED.access_expires_at,
             refresh_expires_at = EXCLUDED.refresh_expires_at,
+            jti = EXCLUDED.jti,
             updated_at = NOW()
     `
 
@@ -45,60 +52,83 @@ func (ts *SimpleTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) e
 	}
 
 	_, err := ts.db.ExecContext(ctx, query,
-		info.GetAccess(),
-		info.GetRefresh(),
+		ts.tokenHasher.Hash(info.GetAccess()),
+		ts.tokenHasher.nullHash(info.GetRefresh()),
 		info.GetClientID(),
 		info.GetUserID(),
 		info.GetScope(),
 		accessExpiresAt,
 		refreshExpiresAt,
+		tokenExtensionValue(info, extAuthorizationCode),
+		tokenExtensionValue(info, extJTI),
+		tokenExtensionTime(info, extAuthTime),
+		tokenExtensionValue(info, extFamilyID),
+		tokenExtensionValue(info, extAMR),
 	)
 
 	return err
 }
 
-// RemoveByAccess удаляет токен по access token
+// RemoveByAccess удаляет токен по хешу access token
 func (ts *SimpleTokenStore) RemoveByAccess(ctx context.Context, access string) error {
-	query := `DELETE FROM oauth2_tokens WHERE access_token = $1`
-	_, err := ts.db.ExecContext(ctx, query, access)
+	query := `DELETE FROM oauth2_tokens WHERE access_token_hash = $1`
+	_, err := ts.db.ExecContext(ctx, query, ts.tokenHasher.Hash(access))
 	return err
 }
 
-// RemoveByRefresh удаляет токен по refresh token
+// RemoveByRefresh помечает refresh токен использованным при ротации
 func (ts *SimpleTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
-	query := `DELETE FROM oauth2_tokens WHERE refresh_token = $1`
-	_, err := ts.db.ExecContext(ctx, query, refresh)
-	return err
+	refreshHash := ts.tokenHasher.Hash(refresh)
+	consumed, err := consumeRefreshToken(ctx, ts.db, refreshHash)
+	if err != nil || consumed {
+		return err
+	}
+
+	// Токен уже использован параллельным запросом
+	rt, err := getRefreshToken(ctx, ts.db, refreshHash)
+	if err != nil {
+		return err
+	}
+	if rt != nil && rt.used {
+		if _, err := revokeTokenFamily(ctx, ts.db, rt.familyID); err != nil {
+			return err
+		}
+	}
+	return oauthErrors.ErrInvalidGrant
 }
 
-// RemoveByCode удаляет токен по authorization code
+// RemoveByCode помечает authorization code использованным
 func (ts *SimpleTokenStore) RemoveByCode(ctx context.Context, code string) error {
-	// В нашей реализации authorization codes хранятся отдельно
-	return nil
+	return consumeAuthorizationCode(ctx, ts.db, code)
 }
 
-// GetByAccess получает токен по access token
+// GetByAccess получает токен по хешу access token. В БД нет исходного refresh токена,
+// поэтому в результате заполнен только access
 func (ts *SimpleTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
 	query := `
-        SELECT access_token, refresh_token, client_id, user_id, scope,
-               access_expires_at, refresh_expires_at, created_at
+        SELECT client_id, user_id, scope,
+               access_expires_at, refresh_expires_at, created_at, authorization_code, jti, auth_time, amr
         FROM oauth2_tokens 
-        WHERE access_token = $1 AND access_expires_at > NOW()
+        WHERE access_token_hash = $1 AND access_expires_at > NOW()
     `
 
-	var accessToken, refreshToken, clientID, userID, scope string
+	var clientID, userID, scope string
 	var accessExpiresAt, createdAt time.Time
 	var refreshExpiresAt sql.NullTime
+	var authorizationCode, jti, amr sql.NullString
+	var authTime sql.NullTime
 
-	err := ts.db.QueryRowContext(ctx, query, access).Scan(
-		&accessToken,
-		&refreshToken,
+	err := ts.db.QueryRowContext(ctx, query, ts.tokenHasher.Hash(access)).Scan(
 		&clientID,
 		&userID,
 		&scope,
 		&accessExpiresAt,
 		&refreshExpiresAt,
 		&createdAt,
+		&authorizationCode,
+		&jti,
+		&authTime,
+		&amr,
 	)
 
 	if err != nil {
@@ -112,11 +142,11 @@ func (ts *SimpleTokenStore) GetByAccess(ctx context.Context, access string) (oau
 	token := &models.Token{
 		ClientID:        clientID,
 		UserID:          userID,
-		Access:          accessToken,
-		Refresh:         refreshToken,
+		Access:          access,
 		Scope:           scope,
 		AccessCreateAt:  createdAt,
 		AccessExpiresIn: accessExpiresAt.Sub(createdAt),
+		Extension:       tokenExtension(authorizationCode, jti, authTime, amr),
 	}
 
 	if refreshExpiresAt.Valid {
@@ -127,59 +157,40 @@ func (ts *SimpleTokenStore) GetByAccess(ctx context.Context, access string) (oau
 	return token, nil
 }
 
-// GetByRefresh получает токен по refresh token
+// GetByRefresh получает токен по хешу refresh token. Исходный access токен не хранится,
+// поэтому в результате заполнен только refresh. Токен, уже замененный при ротации, не возвращается.
+// Метод вызывается и интроспекцией, поэтому повторное предъявление здесь не обрабатывается:
+// семейство отзывает PostgresStore.RevokeReusedRefreshToken в обработчике refresh grant
 func (ts *SimpleTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
-	query := `
-        SELECT access_token, refresh_token, client_id, user_id, scope,
-               access_expires_at, refresh_expires_at, created_at
-        FROM oauth2_tokens 
-        WHERE refresh_token = $1 AND (refresh_expires_at IS NULL OR refresh_expires_at > NOW())
-    `
-
-	var accessToken, refreshToken, clientID, userID, scope string
-	var accessExpiresAt, createdAt time.Time
-	var refreshExpiresAt sql.NullTime
-
-	err := ts.db.QueryRowContext(ctx, query, refresh).Scan(
-		&accessToken,
-		&refreshToken,
-		&clientID,
-		&userID,
-		&scope,
-		&accessExpiresAt,
-		&refreshExpiresAt,
-		&createdAt,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // Токен не найден или истек
-		}
-		return nil, fmt.Errorf("failed to get token by refresh: %w", err)
+	rt, err := getRefreshToken(ctx, ts.db, ts.tokenHasher.Hash(refresh))
+	if err != nil || rt == nil {
+		return nil, err
 	}
 
-	// Создаем токен
-	token := &models.Token{
-		ClientID:        clientID,
-		UserID:          userID,
-		Access:          accessToken,
-		Refresh:         refreshToken,
-		Scope:           scope,
-		AccessCreateAt:  createdAt,
-		AccessExpiresIn: accessExpiresAt.Sub(createdAt),
+	if rt.used {
+		return nil, nil // Токен уже заменен при ротации
 	}
 
-	if refreshExpiresAt.Valid {
-		token.RefreshCreateAt = createdAt
-		token.RefreshExpiresIn = refreshExpiresAt.Time.Sub(createdAt)
-	}
-
-	return token, nil
+	rt.token.Refresh = refresh
+	return rt.token, nil
 }
 
-// GetByCode получает токен по authorization code
+// GetByCode получает токен по authorization code.
+// Повторное предъявление использованного кода отзывает выпущенные по нему токены.
 func (ts *SimpleTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
-	return nil, nil
+	ac, err := getAuthorizationCode(ctx, ts.db, code)
+	if err != nil || ac == nil {
+		return nil, err
+	}
+
+	if ac.consumed {
+		if _, err := revokeTokensByAuthorizationCode(ctx, ts.db, code); err != nil {
+			return nil, err
+		}
+		return nil, nil // Код уже использован
+	}
+
+	return ac.token, nil
 }
 
 // CleanExpiredTokens очищает истекшие токены
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// TokenHasher вычисляет HMAC-SHA256 токенов. В oauth2_tokens сохраняются только хеши,
+// поэтому утечка содержимого БД не дает действующих bearer токенов. Смена ключа делает
+// недействительными все выданные refresh токены.
+type TokenHasher struct {
+	key []byte
+}
+
+// NewTokenHasher создает TokenHasher с ключом HMAC
+func NewTokenHasher(key []byte) *TokenHasher {
+	return &TokenHasher{key: key}
+}
+
+// Hash хеш токена в hex. Пустой токен остается пустой строкой
+func (th *TokenHasher) Hash(token string) string {
+	if token == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, th.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nullHash хеш токена для необязательного столбца: пустой токен сохраняется как NULL
+func (th *TokenHasher) nullHash(token string) sql.NullString {
+	hash := th.Hash(token)
+	return sql.NullString{String: hash, Valid: hash != ""}
+}
+
+// HashStoredTokens хеширует токены, сохраненные в открытом виде до миграции 016,
+// и очищает исходные значения. Повторный запуск обрабатывает только оставшиеся записи.
+func (s *PostgresStore) HashStoredTokens(ctx context.Context) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT id, access_token, COALESCE(refresh_token, '')
+        FROM oauth2_tokens
+        WHERE access_token_hash IS NULL AND access_token IS NOT NULL
+        FOR UPDATE
+    `)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select plaintext tokens: %w", err)
+	}
+
+	type plaintextToken struct {
+		id, access, refresh string
+	}
+	var tokens []plaintextToken
+	for rows.Next() {
+		var token plaintextToken
+		if err := rows.Scan(&token.id, &token.access, &token.refresh); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan plaintext token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to select plaintext tokens: %w", err)
+	}
+
+	query := `
+        UPDATE oauth2_tokens
+        SET access_token_hash = $2, refresh_token_hash = $3, access_token = NULL, refresh_token = NULL
+        WHERE id = $1
+    `
+	for _, token := range tokens {
+		if _, err := tx.ExecContext(ctx, query, token.id, s.tokenHasher.Hash(token.access), s.tokenHasher.nullHash(token.refresh)); err != nil {
+			return 0, fmt.Errorf("failed to hash stored token: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit token hashing: %w", err)
+	}
+	return int64(len(tokens)), nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go_oauth2_server/internal/models"
+)
+
+// Назначение одноразовых токенов пользователя, которые отправляются по электронной почте
+const (
+	UserTokenEmailVerification = "email_verification"
+	UserTokenPasswordReset     = "password_reset"
+)
+
+// userTokensRetention срок хранения использованных и истекших токенов
+const userTokensRetention = 24 * time.Hour
+
+// CreateUserToken сохраняет хеш одноразового токена для адреса email. Возвращает false, если токен
+// с тем же назначением выдан пользователю менее cooldown назад: повторные запросы не рассылают письма
+func (s *PostgresStore) CreateUserToken(ctx context.Context, purpose, userID, email, token string, ttl, cooldown time.Duration) (bool, error) {
+	query := `
+        INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at)
+        SELECT $1, $2, $3, $4, $5
+        WHERE NOT EXISTS (
+            SELECT 1 FROM user_tokens WHERE user_id = $2 AND purpose = $3 AND created_at > $6
+        )
+    `
+
+	now := time.Now()
+	result, err := s.db.ExecContext(ctx, query,
+		s.tokenHasher.Hash(token), userID, purpose, email, now.Add(ttl), now.Add(-cooldown),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to create user token: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// UseUserToken отмечает действующий токен использованным и возвращает его. Для неизвестного,
+// истекшего или уже использованного токена возвращает nil, nil
+func (s *PostgresStore) UseUserToken(ctx context.Context, purpose, token string) (*models.UserToken, error) {
+	query := `
+        UPDATE user_tokens
+        SET used_at = NOW()
+        WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+        RETURNING user_id, purpose, email, expires_at
+    `
+
+	userToken := &models.UserToken{}
+	err := s.db.QueryRowContext(ctx, query, s.tokenHasher.Hash(token), purpose).Scan(
+		&userToken.UserID, &userToken.Purpose, &userToken.Email, &userToken.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to use user token: %w", err)
+	}
+	return userToken, nil
+}
+
+// MarkEmailVerified подтверждает адрес пользователя, если он не изменился с отправки письма
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, userID, email string) (bool, error) {
+	query := `
+        UPDATE users
+        SET email_verified = TRUE, updated_at = NOW()
+        WHERE id = $1 AND LOWER(email) = LOWER($2)
+    `
+
+	result, err := s.db.ExecContext(ctx, query, userID, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// ResetPassword заменяет пароль пользователя после сброса по ссылке из письма. Остальные ссылки
+// сброса, сессии и неиспользованные authorization codes удаляются, выданные токены отзываются
+func (s *PostgresStore) ResetPassword(ctx context.Context, userID, password string) error {
+	if err := s.replacePassword(ctx, userID, password); err != nil {
+		return err
+	}
+
+	if _, err := revokeTokensWhere(ctx, s.db, "user_id = $1", userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cleanExpiredUserTokens удаляет использованные и истекшие токены пользователей
+func cleanExpiredUserTokens(ctx context.Context, db *sql.DB) (int64, error) {
+	query := `DELETE FROM user_tokens WHERE expires_at < $1 OR used_at < $1`
+	result, err := db.ExecContext(ctx, query, time.Now().Add(-userTokensRetention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired user tokens: %w", err)
+	}
+	return result.RowsAffected()
+}
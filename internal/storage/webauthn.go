@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go_oauth2_server/internal/models"
+)
+
+// CreatePasskey сохраняет зарегистрированный ключ WebAuthn
+func (s *PostgresStore) CreatePasskey(ctx context.Context, passkey *models.Passkey) error {
+	query := `
+        INSERT INTO webauthn_credentials (id, user_id, name, public_key, attestation_type, aaguid,
+            sign_count, transports, backup_eligible, backup_state)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    `
+	_, err := s.db.ExecContext(ctx, query,
+		passkey.ID, passkey.UserID, passkey.Name, passkey.PublicKey, passkey.AttestationType, passkey.AAGUID,
+		int64(passkey.SignCount), strings.Join(passkey.Transports, " "), passkey.BackupEligible, passkey.BackupState,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create passkey: %w", err)
+	}
+	return nil
+}
+
+// GetPasskey получает ключ WebAuthn по идентификатору учетных данных. Если ключа нет, возвращает nil, nil
+func (s *PostgresStore) GetPasskey(ctx context.Context, id []byte) (*models.Passkey, error) {
+	query := `
+        SELECT id, user_id, name, public_key, attestation_type, aaguid, sign_count, transports,
+            backup_eligible, backup_state, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE id = $1
+    `
+
+	passkey, err := scanPasskey(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get passkey: %w", err)
+	}
+	return passkey, nil
+}
+
+// ListPasskeys ключи WebAuthn пользователя в порядке регистрации
+func (s *PostgresStore) ListPasskeys(ctx context.Context, userID string) ([]*models.Passkey, error) {
+	query := `
+        SELECT id, user_id, name, public_key, attestation_type, aaguid, sign_count, transports,
+            backup_eligible, backup_state, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE user_id = $1
+        ORDER BY created_at
+    `
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var passkeys []*models.Passkey
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan passkey: %w", err)
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	return passkeys, nil
+}
+
+// CountPasskeys число ключей WebAuthn пользователя
+func (s *PostgresStore) CountPasskeys(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1`
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count passkeys: %w", err)
+	}
+	return count, nil
+}
+
+// UsePasskey сохраняет счетчик подписей и флаг резервной копии после входа. Возвращает false, если
+// счетчик уже не меньше signCount: из двух одновременных входов с одной подписью успешным будет
+// только один. Нулевой счетчик означает аутентификатор без счетчика и не сравнивается
+func (s *PostgresStore) UsePasskey(ctx context.Context, id []byte, signCount uint32, backupState bool) (bool, error) {
+	query := `
+        UPDATE webauthn_credentials
+        SET sign_count = $2, backup_state = $3, last_used_at = NOW()
+        WHERE id = $1 AND (sign_count < $2 OR $2 = 0)
+    `
+
+	result, err := s.db.ExecContext(ctx, query, id, int64(signCount), backupState)
+	if err != nil {
+		return false, fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// DeletePasskey удаляет ключ WebAuthn пользователя
+func (s *PostgresStore) DeletePasskey(ctx context.Context, userID string, id []byte) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete passkey: %w", err)
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// scanPasskey читает ключ WebAuthn из строки результата
+func scanPasskey(row interface{ Scan(...interface{}) error }) (*models.Passkey, error) {
+	passkey := &models.Passkey{}
+	var signCount int64
+	var transports string
+	var lastUsedAt sql.NullTime
+	err := row.Scan(
+		&passkey.ID, &passkey.UserID, &passkey.Name, &passkey.PublicKey, &passkey.AttestationType,
+		&passkey.AAGUID, &signCount, &transports, &passkey.BackupEligible, &passkey.BackupState,
+		&passkey.CreatedAt, &lastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey.SignCount = uint32(signCount)
+	passkey.Transports = strings.Fields(transports)
+	if lastUsedAt.Valid {
+		passkey.LastUsedAt = &lastUsedAt.Time
+	}
+	return passkey, nil
+}
+
+// SaveWebAuthnChallenge сохраняет состояние начатой церемонии WebAuthn
+func (s *PostgresStore) SaveWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	query := `
+        INSERT INTO webauthn_challenges (id, user_id, ceremony, session_data, expires_at)
+        VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5)
+    `
+	_, err := s.db.ExecContext(ctx, query,
+		challenge.ID, challenge.UserID, challenge.Ceremony, challenge.SessionData, challenge.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save WebAuthn challenge: %w", err)
+	}
+	return nil
+}
+
+// TakeWebAuthnChallenge удаляет и возвращает действующую церемонию ceremony. Повторный вызов
+// с тем же id возвращает nil, nil
+func (s *PostgresStore) TakeWebAuthnChallenge(ctx context.Context, id, ceremony string) (*models.WebAuthnChallenge, error) {
+	query := `
+        DELETE FROM webauthn_challenges
+        WHERE id = $1 AND ceremony = $2
+        RETURNING COALESCE(user_id::text, ''), session_data, expires_at
+    `
+
+	challenge := &models.WebAuthnChallenge{ID: id, Ceremony: ceremony}
+	err := s.db.QueryRowContext(ctx, query, id, ceremony).Scan(
+		&challenge.UserID, &challenge.SessionData, &challenge.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to take WebAuthn challenge: %w", err)
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, nil
+	}
+	return challenge, nil
+}
+
+// cleanExpiredWebAuthnChallenges удаляет незавершенные церемонии WebAuthn
+func cleanExpiredWebAuthnChallenges(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean expired WebAuthn challenges: %w", err)
+	}
+	return result.RowsAffected()
+}
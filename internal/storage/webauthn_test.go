@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"go_oauth2_server/internal/hasher"
+	"go_oauth2_server/internal/models"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+// newTestStore подключается к TEST_DATABASE_URL и применяет миграции. Без переменной тест пропускается
+func newTestStore(t *testing.T) *PostgresStore {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		t.Fatalf("create migration driver: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://../../migrations", "postgres", driver)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("run migrations: %v", err)
+	}
+
+	params := hasher.DefaultParams()
+	params.BcryptCost = 4
+	secretHasher, err := hasher.New(hasher.Bcrypt, params)
+	if err != nil {
+		t.Fatalf("create hasher: %v", err)
+	}
+	return NewPostgresStore(db, secretHasher, secretHasher, NewTokenHasher([]byte("test-token-hash-secret")))
+}
+
+func TestUsePasskeySignCount(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	user := &models.User{
+		ID:        uuid.NewString(),
+		Username:  "passkey-" + uuid.NewString(),
+		Password:  "password",
+		CreatedAt: time.Now(),
+	}
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { store.db.Exec(`DELETE FROM users WHERE id = $1`, user.ID) })
+
+	passkey := &models.Passkey{
+		ID:              []byte(uuid.NewString()),
+		UserID:          user.ID,
+		Name:            "Test key",
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+		SignCount:       5,
+	}
+	if err := store.CreatePasskey(ctx, passkey); err != nil {
+		t.Fatalf("create passkey: %v", err)
+	}
+
+	steps := []struct {
+		name      string
+		signCount uint32
+		want      bool
+		stored    uint32
+	}{
+		{"increased", 6, true, 6},
+		{"replayed", 6, false, 6},
+		{"regressed", 3, false, 6},
+		{"increased after regression", 10, true, 10},
+		// Аутентификатор без счетчика всегда передает 0
+		{"counterless", 0, true, 0},
+		{"counterless again", 0, true, 0},
+	}
+	for _, step := range steps {
+		updated, err := store.UsePasskey(ctx, passkey.ID, step.signCount, false)
+		if err != nil {
+			t.Fatalf("%s: use passkey: %v", step.name, err)
+		}
+		if updated != step.want {
+			t.Errorf("%s: updated = %v, want %v", step.name, updated, step.want)
+		}
+
+		stored, err := store.GetPasskey(ctx, passkey.ID)
+		if err != nil {
+			t.Fatalf("%s: get passkey: %v", step.name, err)
+		}
+		if stored.SignCount != step.stored {
+			t.Errorf("%s: stored sign count = %d, want %d", step.name, stored.SignCount, step.stored)
+		}
+	}
+}
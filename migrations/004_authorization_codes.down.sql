@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_oauth2_tokens_authorization_code;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS authorization_code;
+DROP TABLE IF EXISTS oauth2_authorization_codes;
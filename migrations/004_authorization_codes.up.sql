@@ -0,0 +1,19 @@
+-- Authorization codes (RFC 6749 §4.1)
+CREATE TABLE IF NOT EXISTS oauth2_authorization_codes (
+    code VARCHAR(512) PRIMARY KEY,
+    client_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255),
+    redirect_uri TEXT,
+    scope TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed BOOLEAN NOT NULL DEFAULT FALSE,
+    consumed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_oauth2_authorization_codes_client_id ON oauth2_authorization_codes(client_id);
+CREATE INDEX IF NOT EXISTS idx_oauth2_authorization_codes_expires_at ON oauth2_authorization_codes(expires_at);
+
+-- Связь токенов с кодом, из которого они выпущены (для отзыва при повторном использовании кода)
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS authorization_code VARCHAR(512);
+CREATE INDEX IF NOT EXISTS idx_oauth2_tokens_authorization_code ON oauth2_tokens(authorization_code);
@@ -0,0 +1,4 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS require_pkce;
+ALTER TABLE clients DROP COLUMN IF EXISTS is_public;
+ALTER TABLE oauth2_authorization_codes DROP COLUMN IF EXISTS code_challenge_method;
+ALTER TABLE oauth2_authorization_codes DROP COLUMN IF EXISTS code_challenge;
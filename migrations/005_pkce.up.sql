@@ -0,0 +1,7 @@
+-- PKCE (RFC 7636)
+ALTER TABLE oauth2_authorization_codes ADD COLUMN IF NOT EXISTS code_challenge VARCHAR(128);
+ALTER TABLE oauth2_authorization_codes ADD COLUMN IF NOT EXISTS code_challenge_method VARCHAR(16);
+
+-- Публичные клиенты (SPA, мобильные приложения) не хранят секрет и обязаны использовать PKCE
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS is_public BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS require_pkce BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS revoked_tokens;
+DROP INDEX IF EXISTS idx_oauth2_tokens_jti;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS jti;
@@ -0,0 +1,12 @@
+-- Отзыв токенов (RFC 7009)
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS jti VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_oauth2_tokens_jti ON oauth2_tokens(jti);
+
+-- Denylist отозванных JWT. Запись нужна только до истечения токена
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
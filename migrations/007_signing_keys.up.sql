@@ -0,0 +1,15 @@
+-- Ключи подписи JWT с ротацией
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(128) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- момент, когда ключ перестал подписывать токены (NULL — активный ключ)
+    retired_at TIMESTAMP WITH TIME ZONE,
+    -- после этого момента ключ удаляется из JWKS: все подписанные им токены истекли
+    retire_after TIMESTAMP WITH TIME ZONE
+    );
+
+-- Активный ключ подписи может быть только один
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active ON signing_keys((retired_at IS NULL)) WHERE retired_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_signing_keys_retire_after ON signing_keys(retire_after);
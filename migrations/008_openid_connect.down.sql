@@ -0,0 +1,16 @@
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS address;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_number_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_number;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
+ALTER TABLE users DROP COLUMN IF EXISTS zoneinfo;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS picture;
+ALTER TABLE users DROP COLUMN IF EXISTS nickname;
+ALTER TABLE users DROP COLUMN IF EXISTS family_name;
+ALTER TABLE users DROP COLUMN IF EXISTS given_name;
+ALTER TABLE users DROP COLUMN IF EXISTS name;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth2_authorization_codes DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth2_authorization_codes DROP COLUMN IF EXISTS nonce;
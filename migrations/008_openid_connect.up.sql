@@ -0,0 +1,19 @@
+-- OpenID Connect: nonce и время аутентификации передаются из /authorize в ID токен
+ALTER TABLE oauth2_authorization_codes ADD COLUMN IF NOT EXISTS nonce VARCHAR(512);
+ALTER TABLE oauth2_authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE;
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP WITH TIME ZONE;
+
+-- Стандартные claims профиля пользователя (OpenID Connect Core §5.1)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS name VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS given_name VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_name VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS picture TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS zoneinfo VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_number VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_number_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS address JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
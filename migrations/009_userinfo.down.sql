@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS userinfo_signed_response_alg;
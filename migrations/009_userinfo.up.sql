@@ -0,0 +1,2 @@
+-- Алгоритм подписи ответа UserInfo (OpenID Connect Dynamic Client Registration §2). NULL — ответ в JSON
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS userinfo_signed_response_alg VARCHAR(16);
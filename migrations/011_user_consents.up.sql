@@ -0,0 +1,16 @@
+-- Согласия пользователей на доступ клиентов к scope
+CREATE TABLE IF NOT EXISTS user_consents (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(255) NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_user_consents_client_id ON user_consents(client_id);
+
+CREATE TRIGGER update_user_consents_updated_at
+    BEFORE UPDATE ON user_consents
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,6 @@
+-- Зарегистрированные redirect URI клиента. Запрос авторизации должен совпадать с одним из них посимвольно
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+-- Раньше redirect URI сверялся с доменом клиента, поэтому домен в виде URL становится единственным redirect URI
+UPDATE clients SET redirect_uris = ARRAY[domain]
+WHERE cardinality(redirect_uris) = 0 AND domain ~ '^https?://';
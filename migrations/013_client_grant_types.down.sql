@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS response_types;
+ALTER TABLE clients DROP COLUMN IF EXISTS grant_types;
@@ -0,0 +1,8 @@
+-- Разрешенные клиенту grant types и response types (RFC 7591 §2)
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS grant_types TEXT[] NOT NULL DEFAULT '{authorization_code,refresh_token}';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS response_types TEXT[] NOT NULL DEFAULT '{code}';
+
+-- Существующие конфиденциальные клиенты сохраняют client_credentials. Password grant
+-- выдается только явно, так как клиент с ним получает пароли пользователей
+UPDATE clients SET grant_types = '{authorization_code,refresh_token,client_credentials}'
+WHERE NOT is_public;
@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS allowed_scopes;
+DROP TABLE IF EXISTS scopes;
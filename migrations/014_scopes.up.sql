@@ -0,0 +1,23 @@
+-- Реестр scope. is_default выдаются, если клиент не запросил scope; restricted доступны только
+-- клиентам, которым они назначены явно
+CREATE TABLE IF NOT EXISTS scopes (
+    name VARCHAR(255) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    restricted BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+    );
+
+-- read и write используются в примерах API и существующими клиентами
+INSERT INTO scopes (name, description) VALUES
+    ('read', 'Чтение ваших данных'),
+    ('write', 'Изменение ваших данных'),
+    ('openid', 'Подтверждение вашей личности'),
+    ('profile', 'Имя, псевдоним, фото, язык и часовой пояс'),
+    ('email', 'Адрес электронной почты'),
+    ('phone', 'Номер телефона'),
+    ('address', 'Почтовый адрес')
+ON CONFLICT (name) DO NOTHING;
+
+-- Scope, разрешенные клиенту. Пустой список — все scope, кроме restricted
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS allowed_scopes TEXT[] NOT NULL DEFAULT '{}';
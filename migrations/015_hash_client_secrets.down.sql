@@ -0,0 +1,2 @@
+-- Хеширование необратимо: секреты клиентов, созданных до миграции, нужно выпустить заново
+SELECT 1;
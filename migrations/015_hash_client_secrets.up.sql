@@ -0,0 +1,6 @@
+-- Секреты клиентов хранятся как хеши. Существующие секреты в открытом виде хешируются bcrypt
+-- (формат $2a$ совместим с golang.org/x/crypto/bcrypt)
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+UPDATE clients SET secret = crypt(secret, gen_salt('bf', 10))
+WHERE secret <> '' AND secret NOT LIKE '$2_$%' AND secret NOT LIKE '$argon2id$%';
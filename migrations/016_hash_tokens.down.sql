@@ -0,0 +1,8 @@
+-- Хеширование необратимо: токены без исходного значения удаляются, клиентам нужно получить их заново
+DELETE FROM oauth2_tokens WHERE access_token IS NULL;
+ALTER TABLE oauth2_tokens ALTER COLUMN access_token SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_oauth2_tokens_refresh_token_hash;
+DROP INDEX IF EXISTS idx_oauth2_tokens_access_token_hash;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS refresh_token_hash;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS access_token_hash;
@@ -0,0 +1,9 @@
+-- В oauth2_tokens хранятся только HMAC-SHA256 токенов. Ключ HMAC известен лишь приложению,
+-- поэтому существующие записи хешируются при запуске сервера (HashStoredTokens), а исходные
+-- столбцы очищаются и остаются до следующей миграции
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS access_token_hash VARCHAR(64);
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS refresh_token_hash VARCHAR(64);
+ALTER TABLE oauth2_tokens ALTER COLUMN access_token DROP NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth2_tokens_access_token_hash ON oauth2_tokens(access_token_hash);
+CREATE INDEX IF NOT EXISTS idx_oauth2_tokens_refresh_token_hash ON oauth2_tokens(refresh_token_hash);
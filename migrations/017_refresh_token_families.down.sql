@@ -0,0 +1,6 @@
+-- Без отметки использования замененные refresh токены снова стали бы действительными
+DELETE FROM oauth2_tokens WHERE refresh_used_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_oauth2_tokens_family_id;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS refresh_used_at;
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,17 @@
+-- Семейства refresh токенов (OAuth 2.0 Security BCP §4.14): при обновлении выдается новый
+-- refresh токен того же семейства, а использованный помечается refresh_used_at. Повторное
+-- предъявление использованного токена отзывает все семейство
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS refresh_used_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE oauth2_tokens SET family_id = uuid_generate_v4() WHERE family_id IS NULL;
+ALTER TABLE oauth2_tokens ALTER COLUMN family_id SET DEFAULT uuid_generate_v4();
+ALTER TABLE oauth2_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_oauth2_tokens_family_id ON oauth2_tokens(family_id);
+
+-- Выданные ранее refresh токены вычислялись из access токена (sha256) и отзываются:
+-- пользователям нужно войти заново
+UPDATE oauth2_tokens
+SET refresh_token = NULL, refresh_token_hash = NULL, refresh_expires_at = NULL
+WHERE refresh_token IS NOT NULL OR refresh_token_hash IS NOT NULL;
@@ -0,0 +1,2 @@
+DELETE FROM scopes WHERE name = 'admin';
+DROP TABLE IF EXISTS login_failures;
@@ -0,0 +1,18 @@
+-- Учет неудачных входов по имени пользователя и по IP адресу. Счетчик сбрасывается после
+-- окна без ошибок, при достижении лимита субъект блокируется до locked_until. Имена учитываются
+-- независимо от существования пользователя, чтобы блокировка не раскрывала зарегистрированные имена
+CREATE TABLE IF NOT EXISTS login_failures (
+    subject_type VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (subject_type, subject)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure_at ON login_failures(last_failure_at);
+
+-- Scope административного API. Restricted: назначается клиенту только администратором
+INSERT INTO scopes (name, description, restricted) VALUES
+    ('admin', 'Администрирование сервера', TRUE)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,10 @@
+-- Общие корзины token bucket для ограничения частоты запросов между репликами (RATE_LIMIT_STORE=postgres).
+-- allowed хранит результат последнего списания, чтобы вернуть его из того же запроса
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_updated_at ON rate_limits(updated_at);
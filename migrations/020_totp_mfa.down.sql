@@ -0,0 +1,6 @@
+ALTER TABLE oauth2_tokens DROP COLUMN IF EXISTS amr;
+ALTER TABLE oauth2_authorization_codes DROP COLUMN IF EXISTS amr;
+ALTER TABLE sessions DROP COLUMN IF EXISTS amr;
+
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,24 @@
+-- Второй фактор TOTP. Секрет хранится зашифрованным AES-GCM, до подтверждения первым кодом
+-- (confirmed_at IS NULL) вход без второго фактора. last_used_step защищает от повторного использования кода
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+-- Одноразовые коды восстановления, хранятся только HMAC хеши
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, code_hash)
+    );
+
+-- Методы аутентификации (claim amr), через пробел
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS amr VARCHAR(64) NOT NULL DEFAULT 'pwd';
+ALTER TABLE oauth2_authorization_codes ADD COLUMN IF NOT EXISTS amr VARCHAR(64);
+ALTER TABLE oauth2_tokens ADD COLUMN IF NOT EXISTS amr VARCHAR(64);
@@ -0,0 +1,31 @@
+-- Ключи WebAuthn (passkeys) пользователей. id — идентификатор учетных данных аутентификатора,
+-- sign_count — счетчик подписей для обнаружения клонированных ключей
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id BYTEA PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT 'none',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    -- Способы связи с аутентификатором (usb, nfc, ble, internal, hybrid), через пробел
+    transports VARCHAR(100) NOT NULL DEFAULT '',
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- Незавершенные церемонии регистрации и входа. Запись удаляется при завершении церемонии,
+-- поэтому challenge используется один раз
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ceremony VARCHAR(20) NOT NULL,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_challenges_expires_at ON webauthn_challenges(expires_at);
@@ -0,0 +1,32 @@
+-- Адрес электронной почты уникален без учета регистра. Пустые адреса заменяются на NULL, а у повторяющихся
+-- адресов остается один владелец: подтвердивший адрес, иначе зарегистрированный первым
+UPDATE users SET email = NULLIF(TRIM(email), '') WHERE email IS NOT NULL;
+
+UPDATE users SET email = NULL, email_verified = FALSE
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (
+            PARTITION BY LOWER(email) ORDER BY email_verified DESC, created_at, id
+        ) AS position
+        FROM users
+        WHERE email IS NOT NULL
+    ) duplicates
+    WHERE position > 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (LOWER(email));
+
+-- Одноразовые токены подтверждения адреса и сброса пароля, хранятся только HMAC хеши.
+-- email — адрес, на который отправлено письмо: после смены адреса старая ссылка его не подтверждает
+CREATE TABLE IF NOT EXISTS user_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+    );
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id, purpose);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_expires_at ON user_tokens(expires_at);
@@ -0,0 +1,5 @@
+-- Scope API учетной записи /me: профиль, смена пароля, токены, сессии и удаление учетной записи.
+-- Не выдается по умолчанию: клиент запрашивает его явно, пользователь подтверждает на экране согласия
+INSERT INTO scopes (name, description) VALUES
+    ('account', 'Управление учетной записью: профиль, пароль, сессии и удаление')
+ON CONFLICT (name) DO NOTHING;
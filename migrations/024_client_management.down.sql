@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS update_clients_updated_at ON clients;
+DROP INDEX IF EXISTS idx_clients_created_at;
+
+-- Удаленные клиенты без мягкого удаления снова стали бы действующими
+DELETE FROM clients WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE clients DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE clients DROP COLUMN IF EXISTS previous_secret_expires_at;
+ALTER TABLE clients DROP COLUMN IF EXISTS previous_secret;
@@ -0,0 +1,17 @@
+-- Управление клиентами через административный API.
+-- previous_secret — хеш предыдущего секрета, который принимается до previous_secret_expires_at после ротации.
+-- deleted_at — мягкое удаление: строка остается для аудита, клиент перестает аутентифицироваться
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS previous_secret VARCHAR(255);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE clients SET updated_at = created_at;
+
+CREATE INDEX IF NOT EXISTS idx_clients_created_at ON clients(created_at);
+
+DROP TRIGGER IF EXISTS update_clients_updated_at ON clients;
+CREATE TRIGGER update_clients_updated_at
+    BEFORE UPDATE ON clients
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
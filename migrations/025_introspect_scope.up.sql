@@ -0,0 +1,5 @@
+-- Scope, с которым ресурсный сервер вызывает /introspect по Bearer токену client_credentials.
+-- Restricted: назначается клиенту только администратором
+INSERT INTO scopes (name, description, restricted) VALUES
+    ('introspect', 'Проверка токенов ресурсным сервером', TRUE)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,3 @@
+-- Корзины с ключами длиннее 255 символов не переносятся
+DELETE FROM rate_limits WHERE length(key) > 255;
+ALTER TABLE rate_limits ALTER COLUMN key TYPE VARCHAR(255);
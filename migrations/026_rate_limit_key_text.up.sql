@@ -0,0 +1,2 @@
+-- Ключ корзины длиннее 255 символов приводил к ошибке записи, и лимитер пропускал запрос
+ALTER TABLE rate_limits ALTER COLUMN key TYPE TEXT;